@@ -2,20 +2,29 @@ package app
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/RichardKnop/machinery/v1"
 	"github.com/RichardKnop/machinery/v1/tasks"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/chi/v5"
+	"github.com/ikolcov/microblog/internal/auth"
 	"github.com/ikolcov/microblog/internal/models"
 	"github.com/ikolcov/microblog/internal/storage"
 	"github.com/ikolcov/microblog/internal/utils"
+	"github.com/redis/go-redis/v9"
 )
 
 type AppConfig struct {
@@ -23,19 +32,57 @@ type AppConfig struct {
 	MongoUrl    string
 	MongoDbName string
 	RedisUrl    string
+	// PageTokenSecret signs page tokens. It must be the same on all
+	// instances behind a balancer, otherwise tokens are rejected.
+	PageTokenSecret string
+	Feed            storage.FeedConfig
+	Auth            auth.Config
 }
 
 type App struct {
 	config          AppConfig
 	storage         *storage.MongoStorage
 	machineryServer *machinery.Server
+	pageTokens      *utils.PageTokenCodec
+	idempotency     *storage.IdempotencyStorage
+	authenticator   auth.Authenticator
+	handles         *storage.HandleCache
 }
 
+// idempotencyKeyTTL is how long retries of a request are recognized
+const idempotencyKeyTTL = 24 * time.Hour
+
+const maxIdempotencyKeyLength = 255
+
+// handleCacheTTL bounds how long a lookup of a handle is served from cache
+const handleCacheTTL = 10 * time.Minute
+
 func New(config AppConfig, machineryServer *machinery.Server) *App {
+	pageTokenSecret := []byte(config.PageTokenSecret)
+	if len(pageTokenSecret) == 0 {
+		log.Println("Page token secret is not set, using a random one")
+		pageTokenSecret = make([]byte, 32)
+		if _, err := rand.Read(pageTokenSecret); err != nil {
+			panic(err)
+		}
+	}
+
+	authenticator, err := auth.NewAuthenticator(config.Auth)
+	if err != nil {
+		panic(err)
+	}
+
+	mongoStorage := storage.NewMongoStorage(config.MongoUrl, config.MongoDbName, config.Feed)
+	redisClient := redis.NewClient(&redis.Options{Addr: config.RedisUrl})
+
 	return &App{
 		config:          config,
-		storage:         storage.NewMongoStorage(config.MongoUrl, config.MongoDbName),
+		storage:         mongoStorage,
 		machineryServer: machineryServer,
+		pageTokens:      utils.NewPageTokenCodec(pageTokenSecret),
+		idempotency:     storage.NewIdempotencyStorage(redisClient, idempotencyKeyTTL),
+		authenticator:   authenticator,
+		handles:         storage.NewHandleCache(redisClient, mongoStorage, handleCacheTTL),
 	}
 }
 
@@ -48,9 +95,63 @@ func (a *App) notifySubscriber(userId models.UserID) {
 				Value: userId,
 			},
 		},
+		RetryCount: 3,
 	}
 	if _, err := a.machineryServer.SendTaskWithContext(context.Background(), &task); err != nil {
-		panic(err)
+		log.Println("Failed to send notify task:", err)
+	}
+}
+
+// fanOutPost sends a single task, the worker finds the subscribers and
+// updates their feeds
+func (a *App) fanOutPost(post models.Post) {
+	task := tasks.Signature{
+		Name: "fanout",
+		Args: []tasks.Arg{
+			{
+				Type:  "string",
+				Value: post.Id,
+			},
+			{
+				Type:  "string",
+				Value: post.AuthorId,
+			},
+		},
+		RetryCount: 3,
+	}
+	if _, err := a.machineryServer.SendTaskWithContext(context.Background(), &task); err != nil {
+		log.Println("Failed to send fanout task:", err)
+	}
+}
+
+// sendNotification sends a task to notify users about the event. The user
+// is set only for new followers, otherwise the worker finds the users to
+// notify by the post.
+func (a *App) sendNotification(notificationType string, actorId models.UserID, userId models.UserID, postId models.PostID) {
+	task := tasks.Signature{
+		Name: "notification",
+		Args: []tasks.Arg{
+			{
+				Type:  "string",
+				Value: notificationType,
+			},
+			{
+				Type:  "string",
+				Value: actorId,
+			},
+			{
+				Type:  "string",
+				Value: userId,
+			},
+			{
+				Type:  "string",
+				Value: postId,
+			},
+		},
+		RetryCount: 3,
+	}
+	if _, err := a.machineryServer.SendTaskWithContext(context.Background(), &task); err != nil {
+		log.Println("Failed to send notification task:", err)
 	}
 }
 
@@ -61,13 +162,62 @@ func (a *App) addPost(w http.ResponseWriter, r *http.Request) {
 		utils.BadRequest(w, err.Error())
 		return
 	}
+	// retries are recognized by the fields sent by the client
+	request, err := json.Marshal(post)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
 
-	post.AuthorId = models.UserID(r.Header.Get("System-Design-User-Id"))
+	post.AuthorId = auth.UserFromContext(r.Context())
+	post.LikesCount = 0
+	post.Edited = false
+	post.EditCount = 0
+	post.Tags = utils.ExtractHashtags(post.Text)
+	mentions, err := a.resolveMentions(post.Text)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+	post.Mentions = mentions
 	post.CreatedTime = time.Now()
 	post.CreatedAt = post.CreatedTime.Format("2006-01-02T15:04:05.999Z")
 	post.LastModifiedAt = post.CreatedAt
 
+	// a retry of a request that has already created a post gets the same
+	// post back without creating it again
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		utils.BadRequest(w, "idempotency key is too long")
+		return
+	}
+	var reservation *storage.IdempotencyReservation
+	if idempotencyKey != "" && post.AuthorId != "" {
+		var postId models.PostID
+		reservation, postId, err = a.idempotency.Reserve(post.AuthorId, idempotencyKey, request)
+		if errors.Is(err, models.ErrIdempotencyKeyReused) {
+			utils.UnprocessableEntity(w, err.Error())
+			return
+		} else if err != nil {
+			utils.BadRequest(w, err.Error())
+			return
+		}
+		if reservation == nil {
+			a.replayPost(w, r, postId)
+			return
+		}
+	}
+
 	postId, err := a.storage.AddPost(post)
+	if reservation != nil {
+		if err != nil {
+			if err := a.idempotency.Release(reservation); err != nil {
+				log.Println("Failed to release idempotency key:", err)
+			}
+		} else if err := a.idempotency.Complete(reservation, postId); err != nil {
+			log.Println("Failed to store idempotency key:", err)
+		}
+	}
 	if errors.Is(err, models.ErrUnauthorized) {
 		utils.Unauthorized(w, err.Error())
 		return
@@ -75,16 +225,108 @@ func (a *App) addPost(w http.ResponseWriter, r *http.Request) {
 		utils.BadRequest(w, err.Error())
 		return
 	}
-	post.Id = postId
+	post, err = a.storage.GetPost(postId)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	a.fanOutPost(post)
+	if post.InReplyTo != "" {
+		a.sendNotification(models.NotificationReply, post.AuthorId, "", post.Id)
+	}
+	if len(post.Mentions) > 0 {
+		a.sendNotification(models.NotificationMention, post.AuthorId, "", post.Id)
+	}
+
+	a.respondPost(w, r, post)
+}
+
+// replayPost responds to a retried request with the post created by the
+// first one
+func (a *App) replayPost(w http.ResponseWriter, r *http.Request, postId models.PostID) {
+	if postId == "" {
+		utils.Conflict(w, "request with the same idempotency key is in progress")
+		return
+	}
+
+	post, err := a.storage.GetPost(postId)
+	if errors.Is(err, models.ErrNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	a.respondPost(w, r, post)
+}
+
+// decoratePosts fills in the fields of posts that depend on the user making
+// the request and embeds author profiles if they are requested
+func (a *App) decoratePosts(r *http.Request, posts []models.Post) ([]models.Post, error) {
+	posts, err := a.storage.DecoratePosts(auth.UserFromContext(r.Context()), posts)
+	if err != nil || !expandsProfiles(r) {
+		return posts, err
+	}
+
+	authorsId := make([]models.UserID, 0, len(posts))
+	for _, post := range posts {
+		authorsId = append(authorsId, post.AuthorId)
+	}
+	authors, err := a.storage.GetUserSummaries(authorsId)
+	if err != nil {
+		return nil, err
+	}
+	for i := range posts {
+		posts[i].Author = &authors[i]
+	}
+	return posts, nil
+}
 
-	subscribers, err := a.storage.GetSubscribers(post.AuthorId)
-	if err == nil {
-		for _, subscriber := range subscribers.Users {
-			a.notifySubscriber(subscriber)
+// expandsProfiles checks whether the request asks to embed user profiles
+// with ?expand=profiles
+func expandsProfiles(r *http.Request) bool {
+	for _, expand := range strings.Split(r.URL.Query().Get("expand"), ",") {
+		if expand == "profiles" {
+			return true
 		}
 	}
+	return false
+}
+
+func (a *App) respondPost(w http.ResponseWriter, r *http.Request, post models.Post) {
+	posts, err := a.decoratePosts(r, []models.Post{post})
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	// users who may not see the post cannot tell it from a missing one, as
+	// well as a repost of a post deleted in the meantime
+	if len(posts) == 0 {
+		utils.NotFound(w, models.ErrNotFound.Error())
+		return
+	}
+
+	// The ETag covers the whole body, so it changes with likes, the quoted
+	// post and the fields depending on the user as well. If-Match compares
+	// only the version it starts with.
+	body, err := json.Marshal(posts[0])
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+	etag := utils.RepresentationETag(posts[0].Version, body)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Authorization, System-Design-User-Id")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if r.Method == http.MethodGet && ifNoneMatch != "" && utils.MatchETag(ifNoneMatch, etag) {
+		utils.NotModified(w)
+		return
+	}
 
-	err = utils.RespondJSON(w, http.StatusOK, post)
+	err = utils.RespondJSON(w, http.StatusOK, posts[0])
 	if err != nil {
 		utils.BadRequest(w, err.Error())
 		return
@@ -101,11 +343,47 @@ func (a *App) getPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = utils.RespondJSON(w, http.StatusOK, post)
+	a.respondPost(w, r, post)
+}
+
+// resolveMentions finds the users mentioned in the text by handle or by id.
+// Mentions of unknown users are left as plain text.
+func (a *App) resolveMentions(text string) ([]models.UserID, error) {
+	seen := make(map[models.UserID]bool)
+	mentions := make([]models.UserID, 0)
+	for _, name := range utils.ExtractMentions(text) {
+		userId, err := a.handles.Resolve(strings.ToLower(name))
+		if errors.Is(err, models.ErrUserNotFound) {
+			userId = models.UserID(name)
+			exists, err := a.storage.UserExists(userId)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				continue
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		if !seen[userId] {
+			seen[userId] = true
+			mentions = append(mentions, userId)
+		}
+	}
+	return mentions, nil
+}
+
+// getUserParam returns the user of the {userId} path parameter, which is
+// either an id or a handle prefixed with @
+func (a *App) getUserParam(r *http.Request) (models.UserID, error) {
+	param, err := url.PathUnescape(chi.URLParam(r, "userId"))
 	if err != nil {
-		utils.BadRequest(w, err.Error())
-		return
+		return "", err
 	}
+	if handle := strings.TrimPrefix(param, "@"); handle != param {
+		return a.handles.Resolve(strings.ToLower(handle))
+	}
+	return models.UserID(param), nil
 }
 
 func getParam(r *http.Request, key string, defaultValue int) (int, error) {
@@ -116,20 +394,94 @@ func getParam(r *http.Request, key string, defaultValue int) (int, error) {
 	return strconv.Atoi(param)
 }
 
-func (a *App) getUserPosts(w http.ResponseWriter, r *http.Request) {
-	userId := models.UserID(chi.URLParam(r, "userId"))
-	page, err := getParam(r, "page", 1)
-	if err != nil || page < 1 {
-		utils.BadRequest(w, "invalid page")
-		return
+func (a *App) getPageRequest(r *http.Request) (models.PageRequest, error) {
+	pageRequest := models.PageRequest{}
+	if token := r.URL.Query().Get("page"); token != "" {
+		// numeric pages are deprecated, but old clients still send them
+		if page, err := strconv.Atoi(token); err == nil {
+			if page < 1 {
+				return pageRequest, errors.New("invalid page")
+			}
+			pageRequest.Page = page
+		} else {
+			cursor, err := a.pageTokens.Decode(token)
+			if err != nil {
+				return pageRequest, errors.New("invalid page")
+			}
+			pageRequest.After = &cursor
+		}
 	}
+
 	size, err := getParam(r, "size", 10)
 	if err != nil || size < 1 || size > 100 {
-		utils.BadRequest(w, "invalid size")
+		return pageRequest, errors.New("invalid size")
+	}
+	pageRequest.Size = size
+
+	return pageRequest, nil
+}
+
+// preparePostsPage decorates posts for the user making the request and turns
+// the cursor into a token for the next page
+func (a *App) preparePostsPage(r *http.Request, pageRequest models.PageRequest, postsPage models.PostsPage) (models.PostsPage, error) {
+	posts, err := a.decoratePosts(r, postsPage.Posts)
+	if err != nil {
+		return postsPage, err
+	}
+	postsPage.Posts = posts
+	postsPage.NextPage = a.nextPage(pageRequest, postsPage.Next)
+	return postsPage, nil
+}
+
+// nextPage returns the token of the next page in the same form the client
+// has requested the current one, or nothing for the last page
+func (a *App) nextPage(pageRequest models.PageRequest, next *models.Cursor) string {
+	if next == nil {
+		return ""
+	}
+	if pageRequest.Page > 0 {
+		return fmt.Sprint(pageRequest.Page + 1)
+	}
+	return a.pageTokens.Encode(*next)
+}
+
+func (a *App) respondPostsPage(w http.ResponseWriter, r *http.Request, pageRequest models.PageRequest, postsPage models.PostsPage) {
+	postsPage, err := a.preparePostsPage(r, pageRequest, postsPage)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	err = utils.RespondJSON(w, http.StatusOK, postsPage)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+}
+
+func (a *App) getUserPosts(w http.ResponseWriter, r *http.Request) {
+	userId, err := a.getUserParam(r)
+	if errors.Is(err, models.ErrUserNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+	if visible, err := a.storage.CanSeePosts(auth.UserFromContext(r.Context()), userId); err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	} else if !visible {
+		utils.NotFound(w, models.ErrUserNotFound.Error())
+		return
+	}
+	pageRequest, err := a.getPageRequest(r)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
 		return
 	}
 
-	postsPage, err := a.storage.GetUserPosts(userId, page, size)
+	postsPage, err := a.storage.GetUserPosts(userId, pageRequest)
 	if errors.Is(err, models.ErrBadRequest) {
 		utils.BadRequest(w, err.Error())
 		return
@@ -138,138 +490,1022 @@ func (a *App) getUserPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = utils.RespondJSON(w, http.StatusOK, postsPage)
+	a.respondPostsPage(w, r, pageRequest, postsPage)
+}
+
+func (a *App) getTagPosts(w http.ResponseWriter, r *http.Request) {
+	tag := utils.NormalizeHashtag(chi.URLParam(r, "tag"))
+	pageRequest, err := a.getPageRequest(r)
 	if err != nil {
 		utils.BadRequest(w, err.Error())
 		return
 	}
-}
 
-func (a *App) ping(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
+	postsPage, err := a.storage.GetTagPosts(tag, pageRequest)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	a.respondPostsPage(w, r, pageRequest, postsPage)
 }
 
-func (a *App) updatePost(w http.ResponseWriter, r *http.Request) {
-	var post models.Post
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&post); err != nil {
+func (a *App) getMentions(w http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	pageRequest, err := a.getPageRequest(r)
+	if err != nil {
 		utils.BadRequest(w, err.Error())
 		return
 	}
 
-	post.AuthorId = models.UserID(r.Header.Get("System-Design-User-Id"))
-	post.Id = models.PostID(chi.URLParam(r, "postId"))
-	post.LastModifiedAt = time.Now().Format("2006-01-02T15:04:05.999Z")
-
-	post, err := a.storage.UpdatePost(post)
+	postsPage, err := a.storage.GetMentions(userId, pageRequest)
 	if errors.Is(err, models.ErrUnauthorized) {
 		utils.Unauthorized(w, err.Error())
 		return
-	} else if errors.Is(err, models.ErrFobidden) {
-		utils.Forbidden(w, err.Error())
-		return
-	} else if errors.Is(err, models.ErrNotFound) {
-		utils.NotFound(w, err.Error())
-		return
 	} else if err != nil {
 		utils.BadRequest(w, err.Error())
 		return
 	}
 
-	subscribers, err := a.storage.GetSubscribers(post.AuthorId)
-	if err == nil {
-		for _, subscriber := range subscribers.Users {
-			a.notifySubscriber(subscriber)
+	a.respondPostsPage(w, r, pageRequest, postsPage)
+}
+
+func (a *App) searchPosts(w http.ResponseWriter, r *http.Request) {
+	query := models.SearchQuery{
+		Text:     r.URL.Query().Get("q"),
+		AuthorId: models.UserID(r.URL.Query().Get("author")),
+		Sort:     r.URL.Query().Get("sort"),
+	}
+	if strings.TrimSpace(query.Text) == "" {
+		utils.BadRequest(w, "empty query")
+		return
+	}
+	if query.Sort == "" {
+		query.Sort = models.SearchSortRelevance
+	} else if query.Sort != models.SearchSortRelevance && query.Sort != models.SearchSortRecent {
+		utils.BadRequest(w, "invalid sort")
+		return
+	}
+
+	var err error
+	if since := r.URL.Query().Get("since"); since != "" {
+		if query.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			utils.BadRequest(w, "invalid since")
+			return
+		}
+	}
+	if until := r.URL.Query().Get("until"); until != "" {
+		if query.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			utils.BadRequest(w, "invalid until")
+			return
 		}
 	}
 
-	err = utils.RespondJSON(w, http.StatusOK, post)
+	pageRequest, err := a.getPageRequest(r)
 	if err != nil {
 		utils.BadRequest(w, err.Error())
 		return
 	}
-}
 
-func (a *App) subscribeToUser(w http.ResponseWriter, r *http.Request) {
-	from := models.UserID(r.Header.Get("System-Design-User-Id"))
-	to := models.UserID(chi.URLParam(r, "userId"))
+	postsPage, err := a.storage.SearchPosts(query, pageRequest)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
 
-	err := a.storage.AddSubscription(models.Subscription{
-		From: from,
-		To:   to,
-	})
+	a.respondPostsPage(w, r, pageRequest, postsPage)
+}
+
+func (a *App) getReplies(w http.ResponseWriter, r *http.Request) {
+	postId := models.PostID(chi.URLParam(r, "postId"))
+	pageRequest, err := a.getPageRequest(r)
 	if err != nil {
 		utils.BadRequest(w, err.Error())
 		return
 	}
+	// replies to posts the user may not see are not shown either
+	_, err = a.storage.GetVisiblePost(auth.UserFromContext(r.Context()), postId)
+	if errors.Is(err, models.ErrNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
 
-	a.notifySubscriber(from)
+	postsPage, err := a.storage.GetReplies(postId, pageRequest)
+	if errors.Is(err, models.ErrNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
 
-	w.WriteHeader(http.StatusOK)
+	a.respondPostsPage(w, r, pageRequest, postsPage)
 }
 
-func (a *App) getSubscriptions(w http.ResponseWriter, r *http.Request) {
-	userId := models.UserID(r.Header.Get("System-Design-User-Id"))
-
-	usersList, err := a.storage.GetSubscriptions(userId)
+func (a *App) getThread(w http.ResponseWriter, r *http.Request) {
+	postId := models.PostID(chi.URLParam(r, "postId"))
+	pageRequest, err := a.getPageRequest(r)
 	if err != nil {
 		utils.BadRequest(w, err.Error())
 		return
 	}
-	utils.RespondJSON(w, http.StatusOK, usersList)
-}
 
-func (a *App) getSubscribers(w http.ResponseWriter, r *http.Request) {
-	userId := models.UserID(r.Header.Get("System-Design-User-Id"))
+	thread, err := a.storage.GetThread(postId, pageRequest)
+	if errors.Is(err, models.ErrNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
 
-	usersList, err := a.storage.GetSubscribers(userId)
+	// reposts of deleted posts and posts the user may not see are dropped, so
+	// the post is decorated apart from its ancestors
+	posts, err := a.decoratePosts(r, []models.Post{thread.Post})
 	if err != nil {
 		utils.BadRequest(w, err.Error())
 		return
 	}
-	utils.RespondJSON(w, http.StatusOK, usersList)
-}
-
-func (a *App) getFeed(w http.ResponseWriter, r *http.Request) {
-	userId := models.UserID(r.Header.Get("System-Design-User-Id"))
-	page, err := getParam(r, "page", 1)
-	if err != nil || page < 1 {
-		utils.BadRequest(w, "invalid page")
+	if len(posts) == 0 {
+		utils.NotFound(w, models.ErrNotFound.Error())
 		return
 	}
-	size, err := getParam(r, "size", 10)
-	if err != nil || size < 1 || size > 100 {
-		utils.BadRequest(w, "invalid size")
+	thread.Post = posts[0]
+	thread.Ancestors, err = a.decoratePosts(r, thread.Ancestors)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
 		return
 	}
 
-	postsPage, err := a.storage.GetFeed(userId, page, size)
+	thread.Replies, err = a.preparePostsPage(r, pageRequest, thread.Replies)
 	if err != nil {
 		utils.BadRequest(w, err.Error())
 		return
 	}
 
-	err = utils.RespondJSON(w, http.StatusOK, postsPage)
+	err = utils.RespondJSON(w, http.StatusOK, thread)
 	if err != nil {
 		utils.BadRequest(w, err.Error())
 		return
 	}
 }
 
+func (a *App) ping(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) updatePost(w http.ResponseWriter, r *http.Request) {
+	var post models.Post
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&post); err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	post.AuthorId = auth.UserFromContext(r.Context())
+	post.Id = models.PostID(chi.URLParam(r, "postId"))
+	post.Tags = utils.ExtractHashtags(post.Text)
+	mentions, err := a.resolveMentions(post.Text)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+	post.Mentions = mentions
+	post.LastModifiedAt = time.Now().Format("2006-01-02T15:04:05.999Z")
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != "*" {
+		version, err := utils.ParseETag(ifMatch)
+		if err != nil {
+			utils.PreconditionFailed(w, err.Error())
+			return
+		}
+		post.Version = version
+	}
+
+	post, err = a.storage.UpdatePost(post)
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if errors.Is(err, models.ErrFobidden) {
+		utils.Forbidden(w, err.Error())
+		return
+	} else if errors.Is(err, models.ErrNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if errors.Is(err, models.ErrPreconditionFailed) {
+		utils.PreconditionFailed(w, err.Error())
+		return
+	} else if errors.Is(err, models.ErrConflict) {
+		utils.Conflict(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	a.fanOutPost(post)
+	if len(post.Mentions) > 0 {
+		// users mentioned before the edit are not notified again
+		a.sendNotification(models.NotificationMention, post.AuthorId, "", post.Id)
+	}
+
+	a.respondPost(w, r, post)
+}
+
+func (a *App) getRevisions(w http.ResponseWriter, r *http.Request) {
+	postId := models.PostID(chi.URLParam(r, "postId"))
+
+	// past versions of posts the user may not see are hidden as well
+	post, err := a.storage.GetVisiblePost(auth.UserFromContext(r.Context()), postId)
+	if errors.Is(err, models.ErrNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	revisions, err := a.storage.GetRevisions(post.Id)
+	if errors.Is(err, models.ErrNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	err = utils.RespondJSON(w, http.StatusOK, revisions)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+}
+
+func (a *App) deletePost(w http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	postId := models.PostID(chi.URLParam(r, "postId"))
+
+	err := a.storage.DeletePost(postId, userId)
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if errors.Is(err, models.ErrFobidden) {
+		utils.Forbidden(w, err.Error())
+		return
+	} else if errors.Is(err, models.ErrNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) repostPost(w http.ResponseWriter, r *http.Request) {
+	repost := models.Post{
+		AuthorId:    auth.UserFromContext(r.Context()),
+		RepostOf:    models.PostID(chi.URLParam(r, "postId")),
+		CreatedTime: time.Now(),
+	}
+	repost.CreatedAt = repost.CreatedTime.Format("2006-01-02T15:04:05.999Z")
+	repost.LastModifiedAt = repost.CreatedAt
+
+	repostId, err := a.storage.AddPost(repost)
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if errors.Is(err, models.ErrNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	// the storage may have returned an earlier repost of the same post
+	repost, err = a.storage.GetPost(repostId)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	a.fanOutPost(repost)
+	a.sendNotification(models.NotificationRepost, repost.AuthorId, "", repost.RepostOf)
+
+	a.respondPost(w, r, repost)
+}
+
+func (a *App) unrepostPost(w http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	postId := models.PostID(chi.URLParam(r, "postId"))
+
+	_, err := a.storage.DeleteRepost(postId, userId)
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if errors.Is(err, models.ErrNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) likePost(w http.ResponseWriter, r *http.Request) {
+	a.setLike(w, r, func(postId models.PostID, userId models.UserID) error {
+		if err := a.storage.LikePost(postId, userId); err != nil {
+			return err
+		}
+		a.sendNotification(models.NotificationLike, userId, "", postId)
+		return nil
+	})
+}
+
+func (a *App) unlikePost(w http.ResponseWriter, r *http.Request) {
+	a.setLike(w, r, a.storage.UnlikePost)
+}
+
+func (a *App) setLike(w http.ResponseWriter, r *http.Request, setLike func(models.PostID, models.UserID) error) {
+	userId := auth.UserFromContext(r.Context())
+	postId := models.PostID(chi.URLParam(r, "postId"))
+
+	post, err := a.storage.GetPost(postId)
+	if errors.Is(err, models.ErrNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+	if post.RepostOf != "" {
+		// likes of a repost count towards the reposted post and notify its
+		// author
+		postId = post.RepostOf
+	}
+
+	err = setLike(postId, userId)
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if errors.Is(err, models.ErrNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	post, err = a.storage.GetPost(postId)
+	if errors.Is(err, models.ErrNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	a.respondPost(w, r, post)
+}
+
+// getProfile returns the profile of the user, which is blank until the user
+// fills it in
+func (a *App) getProfile(userId models.UserID) (models.User, error) {
+	user, err := a.storage.GetUser(userId)
+	if errors.Is(err, models.ErrUserNotFound) {
+		return models.User{Id: userId}, nil
+	}
+	return user, err
+}
+
+func (a *App) getUser(w http.ResponseWriter, r *http.Request) {
+	userId, err := a.getUserParam(r)
+	if errors.Is(err, models.ErrUserNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	user, err := a.getProfile(userId)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	err = utils.RespondJSON(w, http.StatusOK, user)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+}
+
+func (a *App) getUserByHandle(w http.ResponseWriter, r *http.Request) {
+	handle := strings.ToLower(strings.TrimPrefix(chi.URLParam(r, "handle"), "@"))
+
+	user, err := a.storage.GetUserByHandle(handle)
+	if errors.Is(err, models.ErrUserNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	err = utils.RespondJSON(w, http.StatusOK, user)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+}
+
+func (a *App) getMe(w http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		utils.Unauthorized(w, models.ErrUnauthorized.Error())
+		return
+	}
+
+	user, err := a.getProfile(userId)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	err = utils.RespondJSON(w, http.StatusOK, user)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+}
+
+func (a *App) updateUser(w http.ResponseWriter, r *http.Request) {
+	var userUpdate models.UserUpdate
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&userUpdate); err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	userId := auth.UserFromContext(r.Context())
+	if userId == "" {
+		utils.Unauthorized(w, models.ErrUnauthorized.Error())
+		return
+	}
+	profileId, err := a.getUserParam(r)
+	if errors.Is(err, models.ErrUserNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+	if userId != profileId {
+		utils.Forbidden(w, "user is not allowed to edit this profile")
+		return
+	}
+	if err := validateUserUpdate(userUpdate); err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+	if userUpdate.Handle != nil {
+		// handles are case insensitive
+		handle := strings.ToLower(*userUpdate.Handle)
+		userUpdate.Handle = &handle
+	}
+
+	previous, err := a.storage.GetUser(userId)
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	user, err := a.storage.UpdateUser(userId, userUpdate)
+	if errors.Is(err, models.ErrHandleTaken) {
+		utils.Conflict(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+	if previous.Handle != "" && previous.Handle != user.Handle {
+		if err := a.handles.Evict(previous.Handle); err != nil {
+			log.Println("Failed to evict handle:", err)
+		}
+	}
+
+	err = utils.RespondJSON(w, http.StatusOK, user)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+}
+
+var handleRegexp = regexp.MustCompile(`^[A-Za-z0-9_]{1,30}$`)
+
+func validateUserUpdate(userUpdate models.UserUpdate) error {
+	if userUpdate.Handle != nil && !handleRegexp.MatchString(*userUpdate.Handle) {
+		return errors.New("handle must consist of up to 30 latin letters, digits and underscores")
+	}
+	if userUpdate.DisplayName != nil && utf8.RuneCountInString(*userUpdate.DisplayName) > 50 {
+		return errors.New("display name is too long")
+	}
+	if userUpdate.Bio != nil && utf8.RuneCountInString(*userUpdate.Bio) > 160 {
+		return errors.New("bio is too long")
+	}
+	if userUpdate.AvatarUrl != nil && *userUpdate.AvatarUrl != "" {
+		avatarUrl, err := url.Parse(*userUpdate.AvatarUrl)
+		if err != nil || avatarUrl.Scheme != "http" && avatarUrl.Scheme != "https" || avatarUrl.Host == "" {
+			return errors.New("avatar url must be an http or https url")
+		}
+	}
+	return nil
+}
+
+func (a *App) subscribeToUser(w http.ResponseWriter, r *http.Request) {
+	from := auth.UserFromContext(r.Context())
+	to, err := a.getUserParam(r)
+	if errors.Is(err, models.ErrUserNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	user, err := a.storage.GetUser(to)
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+	if user.Private {
+		a.requestToFollow(w, from, to)
+		return
+	}
+
+	err = a.storage.AddSubscription(models.Subscription{
+		From: from,
+		To:   to,
+	})
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if errors.Is(err, models.ErrBlocked) {
+		utils.Forbidden(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	a.notifySubscriber(from)
+	a.sendNotification(models.NotificationFollow, from, to, "")
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// requestToFollow creates a follow request to a private account. The
+// subscription is added once the owner approves the request.
+func (a *App) requestToFollow(w http.ResponseWriter, from models.UserID, to models.UserID) {
+	requested, err := a.storage.AddFollowRequest(models.Subscription{
+		From: from,
+		To:   to,
+	})
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if errors.Is(err, models.ErrBlocked) {
+		utils.Forbidden(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	if !requested {
+		// the user is already subscribed
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	a.sendNotification(models.NotificationFollowRequest, from, to, "")
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (a *App) getFollowRequests(w http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+
+	requests, err := a.storage.GetFollowRequests(userId)
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, requests)
+}
+
+func (a *App) approveFollowRequest(w http.ResponseWriter, r *http.Request) {
+	a.answerFollowRequest(w, r, true)
+}
+
+func (a *App) denyFollowRequest(w http.ResponseWriter, r *http.Request) {
+	a.answerFollowRequest(w, r, false)
+}
+
+func (a *App) answerFollowRequest(w http.ResponseWriter, r *http.Request, approve bool) {
+	userId := auth.UserFromContext(r.Context())
+	followerId, err := a.getUserParam(r)
+	if errors.Is(err, models.ErrUserNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	if approve {
+		err = a.storage.ApproveFollowRequest(userId, followerId)
+	} else {
+		err = a.storage.DenyFollowRequest(userId, followerId)
+	}
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if errors.Is(err, models.ErrFollowRequestNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if errors.Is(err, models.ErrBlocked) {
+		utils.Forbidden(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	if approve {
+		a.notifySubscriber(followerId)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) unsubscribeFromUser(w http.ResponseWriter, r *http.Request) {
+	from := auth.UserFromContext(r.Context())
+	to, err := a.getUserParam(r)
+	if errors.Is(err, models.ErrUserNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	err = a.storage.RemoveSubscription(models.Subscription{
+		From: from,
+		To:   to,
+	})
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	a.notifySubscriber(from)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) blockUser(w http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	blockedId, err := a.getUserParam(r)
+	if errors.Is(err, models.ErrUserNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	err = a.storage.BlockUser(userId, blockedId)
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	// subscriptions in both directions are gone, so both feeds are rebuilt
+	a.notifySubscriber(userId)
+	a.notifySubscriber(blockedId)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) unblockUser(w http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	blockedId, err := a.getUserParam(r)
+	if errors.Is(err, models.ErrUserNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	err = a.storage.UnblockUser(userId, blockedId)
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *App) getSubscriptions(w http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+
+	usersList, err := a.storage.GetSubscriptions(userId)
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+	if expandsProfiles(r) {
+		if usersList.Profiles, err = a.storage.GetUserSummaries(usersList.Users); err != nil {
+			utils.BadRequest(w, err.Error())
+			return
+		}
+	}
+	utils.RespondJSON(w, http.StatusOK, usersList)
+}
+
+func (a *App) getSubscribers(w http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+
+	usersList, err := a.storage.GetSubscribers(userId)
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+	if expandsProfiles(r) {
+		if usersList.Profiles, err = a.storage.GetUserSummaries(usersList.Users); err != nil {
+			utils.BadRequest(w, err.Error())
+			return
+		}
+	}
+	utils.RespondJSON(w, http.StatusOK, usersList)
+}
+
+func (a *App) getFeed(w http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	pageRequest, err := a.getPageRequest(r)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	postsPage, err := a.storage.GetFeed(userId, pageRequest)
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	a.respondPostsPage(w, r, pageRequest, postsPage)
+}
+
+func (a *App) getNotifications(w http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	pageRequest, err := a.getPageRequest(r)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	notificationsPage, err := a.storage.GetNotifications(userId, pageRequest)
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+	notificationsPage.NextPage = a.nextPage(pageRequest, notificationsPage.Next)
+
+	err = utils.RespondJSON(w, http.StatusOK, notificationsPage)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+}
+
+func (a *App) readNotifications(w http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+
+	// without a body all notifications are marked as read
+	var request models.ReadNotifications
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&request); err != nil && err != io.EOF {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	err := a.storage.MarkNotificationsRead(userId, request.Ids)
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	unreadCount, err := a.storage.CountUnreadNotifications(userId)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	err = utils.RespondJSON(w, http.StatusOK, models.UnreadNotifications{
+		UnreadCount: unreadCount,
+	})
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+}
+
+const maxMuteKeywordLength = 100
+
+func (a *App) addMute(w http.ResponseWriter, r *http.Request) {
+	var mute models.Mute
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&mute); err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	mute.UserId = auth.UserFromContext(r.Context())
+	if mute.UserId == "" {
+		utils.Unauthorized(w, models.ErrUnauthorized.Error())
+		return
+	}
+	if handle := strings.TrimPrefix(string(mute.MutedUserId), "@"); handle != string(mute.MutedUserId) {
+		mutedUserId, err := a.handles.Resolve(strings.ToLower(handle))
+		if errors.Is(err, models.ErrUserNotFound) {
+			utils.NotFound(w, err.Error())
+			return
+		} else if err != nil {
+			utils.BadRequest(w, err.Error())
+			return
+		}
+		mute.MutedUserId = mutedUserId
+	}
+	// keywords are matched by words regardless of case and punctuation
+	mute.Keyword = strings.Join(utils.SplitWords(mute.Keyword), " ")
+	if err := validateMute(&mute); err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	mute, err := a.storage.AddMute(mute)
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	// the feed is filtered when read, so it does not have to be rebuilt
+	err = utils.RespondJSON(w, http.StatusOK, mute)
+	if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+}
+
+// validateMute checks the mute and sets its expiry time
+func validateMute(mute *models.Mute) error {
+	if (mute.MutedUserId == "") == (mute.Keyword == "") {
+		return errors.New("either a user id or a keyword must be given")
+	}
+	if mute.MutedUserId == mute.UserId {
+		return errors.New("user can not mute themselves")
+	}
+	if utf8.RuneCountInString(mute.Keyword) > maxMuteKeywordLength {
+		return errors.New("keyword is too long")
+	}
+	if mute.ExpiresAt != "" {
+		expiresTime, err := time.Parse(time.RFC3339, mute.ExpiresAt)
+		if err != nil {
+			return errors.New("expiresAt must be an RFC 3339 time")
+		}
+		if !expiresTime.After(time.Now()) {
+			return errors.New("expiresAt must be in the future")
+		}
+		expiresTime = expiresTime.UTC()
+		mute.ExpiresAt = expiresTime.Format(time.RFC3339)
+		mute.ExpiresTime = &expiresTime
+	}
+	return nil
+}
+
+func (a *App) getMutes(w http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+
+	mutesList, err := a.storage.GetMutes(userId)
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+	utils.RespondJSON(w, http.StatusOK, mutesList)
+}
+
+func (a *App) deleteMute(w http.ResponseWriter, r *http.Request) {
+	userId := auth.UserFromContext(r.Context())
+	muteId := chi.URLParam(r, "muteId")
+
+	err := a.storage.DeleteMute(userId, muteId)
+	if errors.Is(err, models.ErrUnauthorized) {
+		utils.Unauthorized(w, err.Error())
+		return
+	} else if errors.Is(err, models.ErrMuteNotFound) {
+		utils.NotFound(w, err.Error())
+		return
+	} else if err != nil {
+		utils.BadRequest(w, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 func (a *App) Start() {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.Logger)
+	r.Use(auth.Middleware(a.authenticator))
 
 	r.Post("/api/v1/posts", a.addPost)
 	r.Get("/api/v1/posts/{postId}", a.getPost)
 	r.Get("/api/v1/users/{userId}/posts", a.getUserPosts)
+	r.Get("/api/v1/tags/{tag}/posts", a.getTagPosts)
+	r.Get("/api/v1/mentions", a.getMentions)
+	r.Get("/api/v1/search/posts", a.searchPosts)
 	r.Get("/maintenance/ping", a.ping)
 	r.Patch("/api/v1/posts/{postId}", a.updatePost)
+	r.Delete("/api/v1/posts/{postId}", a.deletePost)
+	r.Get("/api/v1/posts/{postId}/revisions", a.getRevisions)
+	r.Post("/api/v1/posts/{postId}/like", a.likePost)
+	r.Delete("/api/v1/posts/{postId}/like", a.unlikePost)
+	r.Get("/api/v1/posts/{postId}/replies", a.getReplies)
+	r.Get("/api/v1/posts/{postId}/thread", a.getThread)
+	r.Post("/api/v1/posts/{postId}/repost", a.repostPost)
+	r.Delete("/api/v1/posts/{postId}/repost", a.unrepostPost)
+	r.Get("/api/v1/users/{userId}", a.getUser)
+	r.Get("/api/v1/users/by-handle/{handle}", a.getUserByHandle)
+	r.Patch("/api/v1/users/{userId}", a.updateUser)
+	r.Get("/api/v1/me", a.getMe)
 	r.Post("/api/v1/users/{userId}/subscribe", a.subscribeToUser)
+	r.Delete("/api/v1/users/{userId}/subscribe", a.unsubscribeFromUser)
+	r.Post("/api/v1/users/{userId}/block", a.blockUser)
+	r.Delete("/api/v1/users/{userId}/block", a.unblockUser)
+	r.Get("/api/v1/follow-requests", a.getFollowRequests)
+	r.Post("/api/v1/follow-requests/{userId}/approve", a.approveFollowRequest)
+	r.Post("/api/v1/follow-requests/{userId}/deny", a.denyFollowRequest)
 	r.Get("/api/v1/subscriptions", a.getSubscriptions)
 	r.Get("/api/v1/subscribers", a.getSubscribers)
 	r.Get("/api/v1/feed", a.getFeed)
+	r.Post("/api/v1/mutes", a.addMute)
+	r.Get("/api/v1/mutes", a.getMutes)
+	r.Delete("/api/v1/mutes/{muteId}", a.deleteMute)
+	r.Get("/api/v1/notifications", a.getNotifications)
+	r.Post("/api/v1/notifications/read", a.readNotifications)
 
 	http.ListenAndServe(fmt.Sprintf(":%v", a.config.Port), r)
 }
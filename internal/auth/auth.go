@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/ikolcov/microblog/internal/models"
+	"github.com/ikolcov/microblog/internal/utils"
+)
+
+type Config struct {
+	// JWTKey verifies bearer tokens signed with HS256
+	JWTKey string
+	// AllowUserIdHeader trusts the System-Design-User-Id header of requests
+	// without a bearer token. It is meant for internal deployments only.
+	AllowUserIdHeader bool
+}
+
+// Authenticator finds out the user making the request. It returns an empty
+// id if the request has no credentials it understands and
+// models.ErrUnauthorized if the credentials are invalid.
+type Authenticator interface {
+	Authenticate(r *http.Request) (models.UserID, error)
+}
+
+// chain asks authenticators in turn until one of them recognizes the
+// credentials of the request
+type chain []Authenticator
+
+func (c chain) Authenticate(r *http.Request) (models.UserID, error) {
+	for _, authenticator := range c {
+		userId, err := authenticator.Authenticate(r)
+		if err != nil || userId != "" {
+			return userId, err
+		}
+	}
+	return "", nil
+}
+
+type headerAuthenticator struct{}
+
+func (headerAuthenticator) Authenticate(r *http.Request) (models.UserID, error) {
+	return models.UserID(r.Header.Get("System-Design-User-Id")), nil
+}
+
+func NewAuthenticator(config Config) (Authenticator, error) {
+	authenticators := make(chain, 0)
+	if config.JWTKey != "" {
+		authenticators = append(authenticators, NewJWTAuthenticator([]byte(config.JWTKey)))
+	}
+	if config.AllowUserIdHeader {
+		authenticators = append(authenticators, headerAuthenticator{})
+	}
+	if len(authenticators) == 0 {
+		return nil, errors.New("either a JWT key must be set or the user id header must be allowed")
+	}
+	return authenticators, nil
+}
+
+type contextKey struct{}
+
+func WithUser(ctx context.Context, userId models.UserID) context.Context {
+	return context.WithValue(ctx, contextKey{}, userId)
+}
+
+// UserFromContext returns the authenticated user, or an empty id for an
+// anonymous request
+func UserFromContext(ctx context.Context) models.UserID {
+	userId, _ := ctx.Value(contextKey{}).(models.UserID)
+	return userId
+}
+
+// Middleware rejects requests with invalid credentials and puts the user
+// into the context of the others. Anonymous requests are passed through, it
+// is up to handlers to require a user.
+func Middleware(authenticator Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userId, err := authenticator.Authenticate(r)
+			if err != nil {
+				utils.Unauthorized(w, err.Error())
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), userId)))
+		})
+	}
+}
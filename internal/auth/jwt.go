@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ikolcov/microblog/internal/models"
+)
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+}
+
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf"`
+}
+
+// JWTAuthenticator verifies bearer tokens signed with HS256. The user is the
+// subject of the token.
+type JWTAuthenticator struct {
+	key []byte
+}
+
+func NewJWTAuthenticator(key []byte) *JWTAuthenticator {
+	return &JWTAuthenticator{key: key}
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (models.UserID, error) {
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		return "", nil
+	}
+	// the scheme is case insensitive, see RFC 7235
+	scheme, token, found := strings.Cut(authorization, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", models.ErrUnauthorized
+	}
+	token = strings.TrimSpace(token)
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", models.ErrUnauthorized
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		// other algorithms, "none" in particular, are never accepted
+		return "", models.ErrUnauthorized
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", models.ErrUnauthorized
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", models.ErrUnauthorized
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil || claims.Subject == "" {
+		return "", models.ErrUnauthorized
+	}
+	now := time.Now().Unix()
+	if claims.ExpiresAt != 0 && now >= claims.ExpiresAt || claims.NotBefore != 0 && now < claims.NotBefore {
+		return "", models.ErrUnauthorized
+	}
+
+	return models.UserID(claims.Subject), nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
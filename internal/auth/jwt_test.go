@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ikolcov/microblog/internal/models"
+)
+
+func signToken(key string, header string, claims string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	authenticator := NewJWTAuthenticator([]byte("secret"))
+	token := signToken("secret", `{"alg":"HS256"}`, `{"sub":"alice"}`)
+	expired := signToken("secret", `{"alg":"HS256"}`, `{"sub":"alice","exp":`+formatUnix(time.Now().Add(-time.Minute))+`}`)
+	notYet := signToken("secret", `{"alg":"HS256"}`, `{"sub":"alice","nbf":`+formatUnix(time.Now().Add(time.Minute))+`}`)
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          models.UserID
+		wantErr       bool
+	}{
+		{"anonymous", "", "", false},
+		{"bearer", "Bearer " + token, "alice", false},
+		{"lowercase scheme", "bearer " + token, "alice", false},
+		{"uppercase scheme", "BEARER " + token, "alice", false},
+		{"extra spaces", "Bearer   " + token, "alice", false},
+		{"other scheme", "Basic " + token, "", true},
+		{"no scheme", token, "", true},
+		{"other key", "Bearer " + signToken("other", `{"alg":"HS256"}`, `{"sub":"alice"}`), "", true},
+		{"none algorithm", "Bearer " + signToken("secret", `{"alg":"none"}`, `{"sub":"alice"}`), "", true},
+		{"no subject", "Bearer " + signToken("secret", `{"alg":"HS256"}`, `{}`), "", true},
+		{"expired", "Bearer " + expired, "", true},
+		{"not valid yet", "Bearer " + notYet, "", true},
+		{"malformed", "Bearer abc", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if test.authorization != "" {
+				r.Header.Set("Authorization", test.authorization)
+			}
+			userId, err := authenticator.Authenticate(r)
+			if (err != nil) != test.wantErr || userId != test.want {
+				t.Errorf("Authenticate() = %q, %v, want %q, error %v", userId, err, test.want, test.wantErr)
+			}
+		})
+	}
+}
+
+func formatUnix(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
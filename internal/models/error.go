@@ -6,3 +6,11 @@ var ErrBadRequest = errors.New("bad request")
 var ErrUnauthorized = errors.New("user token is invalid")
 var ErrFobidden = errors.New("user is not allowed to edit this post")
 var ErrNotFound = errors.New("post is not found")
+var ErrUserNotFound = errors.New("user is not found")
+var ErrHandleTaken = errors.New("handle is already taken")
+var ErrBlocked = errors.New("user is blocked")
+var ErrFollowRequestNotFound = errors.New("follow request is not found")
+var ErrMuteNotFound = errors.New("mute is not found")
+var ErrIdempotencyKeyReused = errors.New("idempotency key has been used for another request")
+var ErrPreconditionFailed = errors.New("post version does not match")
+var ErrConflict = errors.New("post has been modified concurrently")
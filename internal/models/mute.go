@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Mute hides posts of a user or posts containing a phrase from the feed of
+// the user who has muted them. Exactly one of MutedUserId and Keyword is set.
+type Mute struct {
+	Id          string `json:"id" bson:"-"`
+	UserId      UserID `json:"-"`
+	MutedUserId UserID `json:"userId,omitempty" bson:"muteduserid,omitempty"`
+	// Keyword is stored as lowercased words separated by single spaces
+	Keyword   string `json:"keyword,omitempty" bson:"keyword,omitempty"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+	// ExpiresTime is nil for a mute without expiry
+	ExpiresTime *time.Time `json:"-"`
+}
+
+type MutesList struct {
+	Mutes []Mute `json:"mutes"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+const (
+	NotificationFollow        = "follow"
+	NotificationFollowRequest = "followRequest"
+	NotificationLike          = "like"
+	NotificationReply         = "reply"
+	NotificationMention       = "mention"
+	NotificationRepost        = "repost"
+)
+
+type Notification struct {
+	Id          string    `json:"id" bson:"-"`
+	UserId      UserID    `json:"-"`
+	Type        string    `json:"type"`
+	ActorId     UserID    `json:"actorId"`
+	PostId      PostID    `json:"postId,omitempty"`
+	CreatedAt   string    `json:"createdAt"`
+	Read        bool      `json:"read"`
+	CreatedTime time.Time `json:"-"`
+}
+
+type NotificationsPage struct {
+	Notifications []Notification `json:"notifications"`
+	UnreadCount   int64          `json:"unreadCount"`
+	NextPage      string         `json:"nextPage,omitempty"`
+	Next          *Cursor        `json:"-"`
+}
+
+type ReadNotifications struct {
+	Ids []string `json:"ids"`
+}
+
+type UnreadNotifications struct {
+	UnreadCount int64 `json:"unreadCount"`
+}
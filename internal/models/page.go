@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Cursor points at the last post of a page. The next page starts right after
+// it, so posts published between requests do not shift the pages.
+type Cursor struct {
+	Id   PostID    `json:"id"`
+	Time time.Time `json:"time"`
+	// Score is the relevance of the post for search results ordered by it
+	Score float64 `json:"score,omitempty"`
+}
+
+type PageRequest struct {
+	// After is nil for the first page
+	After *Cursor
+	// Page is the deprecated numeric page, starting from 1. Zero means that
+	// the client uses cursors.
+	Page int
+	Size int
+}
+
+func (p PageRequest) Offset() int {
+	if p.After != nil || p.Page < 1 {
+		return 0
+	}
+	return (p.Page - 1) * p.Size
+}
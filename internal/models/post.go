@@ -12,6 +12,8 @@ type UserID string
 
 type UsersList struct {
 	Users []UserID `json:"users"`
+	// Profiles are embedded on request, in the same order as the users
+	Profiles []UserSummary `json:"profiles,omitempty"`
 }
 
 type Post struct {
@@ -20,7 +22,56 @@ type Post struct {
 	AuthorId       UserID    `json:"authorId"`
 	CreatedAt      string    `json:"createdAt"`
 	LastModifiedAt string    `json:"lastModifiedAt"`
+	InReplyTo      PostID    `json:"inReplyTo,omitempty"`
+	Tags           []string  `json:"tags,omitempty"`
+	Mentions       []UserID  `json:"mentions,omitempty"`
 	CreatedTime    time.Time `json:"-"`
+	LikesCount     int64     `json:"likesCount"`
+	// LikedByMe depends on the user requesting the post, so it is not stored
+	LikedByMe bool `json:"likedByMe" bson:"-"`
+	// RepostOf is set for a repost record, which is served as the original
+	// post with RepostedBy set to the author of the record
+	RepostOf   PostID `json:"-" bson:"repostof,omitempty"`
+	RepostedBy UserID `json:"repostedBy,omitempty" bson:"-"`
+	// QuotedPost is looked up when the post is served, so that it reflects
+	// edits of the quoted post and disappears once that is deleted
+	QuotedPostId PostID      `json:"quotedPostId,omitempty" bson:"quotedpostid,omitempty"`
+	QuotedPost   *QuotedPost `json:"quotedPost,omitempty" bson:"-"`
+	// Author is the profile of the author embedded on request
+	Author *UserSummary `json:"author,omitempty" bson:"-"`
+	// previous versions of an edited post are kept as revisions
+	Edited    bool  `json:"edited"`
+	EditCount int64 `json:"editCount"`
+	// Version is increased by every edit and is served as the ETag. Posts
+	// created before versions were introduced have none.
+	Version int64 `json:"-"`
+}
+
+// Revision is a version of a post replaced by an edit
+type Revision struct {
+	PostId         PostID `json:"-"`
+	Text           string `json:"text"`
+	LastModifiedAt string `json:"lastModifiedAt"`
+}
+
+type RevisionsList struct {
+	Revisions []Revision `json:"revisions"`
+}
+
+type QuotedPost struct {
+	Id        PostID `json:"id"`
+	AuthorId  UserID `json:"authorId"`
+	Text      string `json:"text"`
+	CreatedAt string `json:"createdAt"`
+}
+
+func NewQuotedPost(post Post) *QuotedPost {
+	return &QuotedPost{
+		Id:        post.Id,
+		AuthorId:  post.AuthorId,
+		Text:      post.Text,
+		CreatedAt: post.CreatedAt,
+	}
 }
 
 type HexId struct {
@@ -28,8 +79,21 @@ type HexId struct {
 }
 
 type PostsPage struct {
-	Posts    []Post `json:"posts"`
-	NextPage string `json:"nextPage,omitempty"`
+	Posts    []Post  `json:"posts"`
+	NextPage string  `json:"nextPage,omitempty"`
+	Next     *Cursor `json:"-"`
+}
+
+type Thread struct {
+	// Ancestors start from the root of the conversation
+	Ancestors []Post    `json:"ancestors"`
+	Post      Post      `json:"post"`
+	Replies   PostsPage `json:"replies"`
+}
+
+type Like struct {
+	PostId PostID
+	UserId UserID
 }
 
 type Subscription struct {
@@ -37,7 +101,34 @@ type Subscription struct {
 	To   UserID
 }
 
+// Block means that the user From has blocked the user To
+type Block struct {
+	From UserID
+	To   UserID
+}
+
+// FollowRequest is a subscription of the user From to the private account of
+// the user To that waits for approval
+type FollowRequest struct {
+	From      UserID `json:"userId"`
+	To        UserID `json:"-"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type FollowRequestsList struct {
+	Requests []FollowRequest `json:"requests"`
+}
+
 type Feed struct {
 	User  UserID
 	Posts []Post
 }
+
+type FollowersCount struct {
+	User  UserID
+	Count int
+	// Popular is set once the author reaches the fan-out threshold. Posts
+	// made since then are not in feeds, so they are merged into feeds on read
+	// even after the author drops below the threshold.
+	Popular bool
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+const (
+	SearchSortRelevance = "relevance"
+	SearchSortRecent    = "recent"
+)
+
+type SearchQuery struct {
+	Text string
+	// AuthorId, Since and Until are optional filters, empty values are ignored
+	AuthorId UserID
+	Since    time.Time
+	Until    time.Time
+	Sort     string
+}
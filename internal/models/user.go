@@ -0,0 +1,39 @@
+package models
+
+// User is the profile of a user. Users do not have to create a profile to
+// post, so a user may have none.
+type User struct {
+	Id          UserID `json:"id" bson:"_id"`
+	Handle      string `json:"handle,omitempty" bson:"handle,omitempty"`
+	DisplayName string `json:"displayName"`
+	Bio         string `json:"bio"`
+	AvatarUrl   string `json:"avatarUrl"`
+	// Private accounts approve their subscribers and show posts to them only
+	Private bool `json:"private"`
+}
+
+// UserUpdate holds the fields of a profile to change, nil fields are kept
+type UserUpdate struct {
+	Handle      *string `json:"handle"`
+	DisplayName *string `json:"displayName"`
+	Bio         *string `json:"bio"`
+	AvatarUrl   *string `json:"avatarUrl"`
+	Private     *bool   `json:"private"`
+}
+
+// UserSummary is the part of a profile embedded into posts and user lists
+type UserSummary struct {
+	Id          UserID `json:"id"`
+	Handle      string `json:"handle,omitempty"`
+	DisplayName string `json:"displayName"`
+	AvatarUrl   string `json:"avatarUrl"`
+}
+
+func NewUserSummary(user User) *UserSummary {
+	return &UserSummary{
+		Id:          user.Id,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		AvatarUrl:   user.AvatarUrl,
+	}
+}
@@ -2,11 +2,11 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
 	"time"
 
 	"github.com/ikolcov/microblog/internal/models"
 	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 type CachedStorage struct {
@@ -15,13 +15,9 @@ type CachedStorage struct {
 }
 
 func (s *CachedStorage) AddPost(post models.Post) (models.PostID, error) {
-	postId, err := s.persistentStorage.AddPost(post)
-	if err != nil {
-		return postId, err
-	}
-	post.Id = postId
-	s.store(post)
-	return postId, nil
+	// the stored post may differ from the given one, e.g. a repeated repost
+	// returns the earlier one, so it is cached on the first read
+	return s.persistentStorage.AddPost(post)
 }
 
 func (s *CachedStorage) GetPost(postId models.PostID) (models.Post, error) {
@@ -36,6 +32,10 @@ func (s *CachedStorage) GetPost(postId models.PostID) (models.Post, error) {
 	return post, nil
 }
 
+func (s *CachedStorage) GetVisiblePost(userId models.UserID, postId models.PostID) (models.Post, error) {
+	return s.persistentStorage.GetVisiblePost(userId, postId)
+}
+
 func (s *CachedStorage) UpdatePost(postUpdate models.Post) (models.Post, error) {
 	post, err := s.persistentStorage.UpdatePost(postUpdate)
 	if err != nil {
@@ -45,12 +45,101 @@ func (s *CachedStorage) UpdatePost(postUpdate models.Post) (models.Post, error)
 	return post, nil
 }
 
-func (s *CachedStorage) GetUserPosts(userId models.UserID, page int, size int) (models.PostsPage, error) {
-	return s.persistentStorage.GetUserPosts(userId, page, size)
+func (s *CachedStorage) GetRevisions(postId models.PostID) (models.RevisionsList, error) {
+	return s.persistentStorage.GetRevisions(postId)
+}
+
+func (s *CachedStorage) GetUserPosts(userId models.UserID, page models.PageRequest) (models.PostsPage, error) {
+	return s.persistentStorage.GetUserPosts(userId, page)
+}
+
+func (s *CachedStorage) GetReplies(postId models.PostID, page models.PageRequest) (models.PostsPage, error) {
+	return s.persistentStorage.GetReplies(postId, page)
+}
+
+func (s *CachedStorage) GetThread(postId models.PostID, page models.PageRequest) (models.Thread, error) {
+	// walking up the chain goes through the cache
+	return getThread(s, postId, page)
+}
+
+func (s *CachedStorage) GetTagPosts(tag string, page models.PageRequest) (models.PostsPage, error) {
+	return s.persistentStorage.GetTagPosts(tag, page)
+}
+
+func (s *CachedStorage) GetMentions(userId models.UserID, page models.PageRequest) (models.PostsPage, error) {
+	return s.persistentStorage.GetMentions(userId, page)
+}
+
+func (s *CachedStorage) SearchPosts(query models.SearchQuery, page models.PageRequest) (models.PostsPage, error) {
+	return s.persistentStorage.SearchPosts(query, page)
+}
+
+func (s *CachedStorage) DeletePost(postId models.PostID, userId models.UserID) error {
+	if err := s.persistentStorage.DeletePost(postId, userId); err != nil {
+		return err
+	}
+	s.evict(postId)
+	return nil
+}
+
+func (s *CachedStorage) DeleteRepost(postId models.PostID, userId models.UserID) (models.PostID, error) {
+	repostId, err := s.persistentStorage.DeleteRepost(postId, userId)
+	if err != nil {
+		return repostId, err
+	}
+	s.evict(repostId)
+	return repostId, nil
 }
 
+func (s *CachedStorage) LikePost(postId models.PostID, userId models.UserID) error {
+	if err := s.persistentStorage.LikePost(postId, userId); err != nil {
+		return err
+	}
+	s.evictLiked(postId)
+	return nil
+}
+
+func (s *CachedStorage) UnlikePost(postId models.PostID, userId models.UserID) error {
+	if err := s.persistentStorage.UnlikePost(postId, userId); err != nil {
+		return err
+	}
+	s.evictLiked(postId)
+	return nil
+}
+
+// evictLiked evicts the post whose likes have changed, which is the reposted
+// post for a repost
+func (s *CachedStorage) evictLiked(postId models.PostID) {
+	if post, err := s.persistentStorage.GetPost(postId); err == nil && post.RepostOf != "" {
+		postId = post.RepostOf
+	}
+	s.evict(postId)
+}
+
+func (s *CachedStorage) BlockUser(userId models.UserID, blockedId models.UserID) error {
+	return s.persistentStorage.BlockUser(userId, blockedId)
+}
+
+func (s *CachedStorage) UnblockUser(userId models.UserID, blockedId models.UserID) error {
+	return s.persistentStorage.UnblockUser(userId, blockedId)
+}
+
+func (s *CachedStorage) IsBlocked(userId models.UserID, otherId models.UserID) (bool, error) {
+	return s.persistentStorage.IsBlocked(userId, otherId)
+}
+
+func (s *CachedStorage) CanSeePosts(viewerId models.UserID, authorId models.UserID) (bool, error) {
+	return s.persistentStorage.CanSeePosts(viewerId, authorId)
+}
+
+func (s *CachedStorage) DecoratePosts(viewerId models.UserID, posts []models.Post) ([]models.Post, error) {
+	return s.persistentStorage.DecoratePosts(viewerId, posts)
+}
+
+// store keeps posts in bson, as the fields hidden from clients in json are
+// needed as well
 func (s *CachedStorage) store(post models.Post) {
-	value, err := json.Marshal(post)
+	value, err := bson.Marshal(post)
 	if err != nil {
 		panic(err)
 	}
@@ -60,7 +149,7 @@ func (s *CachedStorage) store(post models.Post) {
 }
 
 func (s *CachedStorage) load(postId models.PostID) *models.Post {
-	result, err := s.client.Get(context.TODO(), s.redisKey(postId)).Result()
+	result, err := s.client.Get(context.TODO(), s.redisKey(postId)).Bytes()
 	if err == redis.Nil {
 		return nil
 	}
@@ -68,15 +157,22 @@ func (s *CachedStorage) load(postId models.PostID) *models.Post {
 		panic(err)
 	}
 	var post models.Post
-	if err := json.Unmarshal([]byte(result), &post); err != nil {
+	if err := bson.Unmarshal(result, &post); err != nil {
 		panic(err)
 	}
 	return &post
 }
 
+func (s *CachedStorage) evict(postId models.PostID) {
+	if err := s.client.Del(context.TODO(), s.redisKey(postId)).Err(); err != nil {
+		panic(err)
+	}
+}
+
 func (s *CachedStorage) redisKey(key models.PostID) string {
-	// add a prefix not to collide with other data stored in the same redis
-	return "postid:" + string(key)
+	// add a prefix not to collide with other data stored in the same redis,
+	// posts cached in json before are left to expire under the older one
+	return "post:" + string(key)
 }
 
 func NewCachedStorage(redisUrl string, persistentStorage Storage) Storage {
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/ikolcov/microblog/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// HandleCache resolves user handles to ids, keeping recent lookups in redis
+type HandleCache struct {
+	client  *redis.Client
+	storage *MongoStorage
+	ttl     time.Duration
+}
+
+func (c *HandleCache) Resolve(handle string) (models.UserID, error) {
+	userId, err := c.client.Get(context.TODO(), c.redisKey(handle)).Result()
+	if err == nil {
+		return models.UserID(userId), nil
+	}
+	if err != redis.Nil {
+		return "", err
+	}
+
+	user, err := c.storage.GetUserByHandle(handle)
+	if err != nil {
+		return "", err
+	}
+	if err := c.client.Set(context.TODO(), c.redisKey(handle), string(user.Id), c.ttl).Err(); err != nil {
+		return "", err
+	}
+
+	// the handle may have been changed and evicted between the lookup and the
+	// write, so check it again not to keep a stale mapping
+	current, err := c.storage.GetUserByHandle(handle)
+	if err == nil && current.Id == user.Id {
+		return user.Id, nil
+	}
+	if err := c.Evict(handle); err != nil {
+		return "", err
+	}
+	return current.Id, err
+}
+
+// Evict forgets the handle once it is changed, so that it can be taken by
+// another user
+func (c *HandleCache) Evict(handle string) error {
+	return c.client.Del(context.TODO(), c.redisKey(handle)).Err()
+}
+
+func (c *HandleCache) redisKey(handle string) string {
+	return "handle:" + handle
+}
+
+func NewHandleCache(client *redis.Client, storage *MongoStorage, ttl time.Duration) *HandleCache {
+	return &HandleCache{
+		client:  client,
+		storage: storage,
+		ttl:     ttl,
+	}
+}
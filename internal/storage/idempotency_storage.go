@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ikolcov/microblog/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// pendingTTL limits how long a key stays reserved if the request holding it
+// never completes, e.g. because the instance has crashed
+const pendingTTL = time.Minute
+
+// IdempotencyStorage remembers posts created by requests with an
+// Idempotency-Key, so that retries of a request do not create posts again
+type IdempotencyStorage struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// idempotencyRecord is stored under a key. A pending record holds the token
+// of the request that has reserved the key, a completed one holds the post.
+type idempotencyRecord struct {
+	Token    string        `json:"token,omitempty"`
+	BodyHash string        `json:"bodyHash"`
+	PostId   models.PostID `json:"postId,omitempty"`
+}
+
+// IdempotencyReservation is a key reserved by the current request
+type IdempotencyReservation struct {
+	redisKey string
+	record   idempotencyRecord
+}
+
+// the record is only replaced or deleted by the request holding the
+// reservation, as it may have expired and been taken by another request
+var (
+	completeScript = redis.NewScript(`
+local record = redis.call('GET', KEYS[1])
+if not record or cjson.decode(record).token ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+return 1`)
+	releaseScript = redis.NewScript(`
+local record = redis.call('GET', KEYS[1])
+if not record or cjson.decode(record).token ~= ARGV[1] then
+	return 0
+end
+return redis.call('DEL', KEYS[1])`)
+)
+
+// Reserve marks the key as taken by the current request. If the key has
+// already been used, the post created with it is returned instead, or an
+// empty id while the first request is still in progress. Reusing the key for
+// a request with another body is an error.
+func (s *IdempotencyStorage) Reserve(userId models.UserID, key string, body []byte) (*IdempotencyReservation, models.PostID, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, "", err
+	}
+	bodyHash := sha256.Sum256(body)
+	reservation := &IdempotencyReservation{
+		redisKey: s.redisKey(userId, key),
+		record: idempotencyRecord{
+			Token:    base64.RawURLEncoding.EncodeToString(token),
+			BodyHash: base64.RawURLEncoding.EncodeToString(bodyHash[:]),
+		},
+	}
+	pending, err := json.Marshal(reservation.record)
+	if err != nil {
+		return nil, "", err
+	}
+
+	reserved, err := s.client.SetNX(context.TODO(), reservation.redisKey, pending, pendingTTL).Result()
+	if err != nil || reserved {
+		return reservation, "", err
+	}
+
+	value, err := s.client.Get(context.TODO(), reservation.redisKey).Bytes()
+	if err == redis.Nil {
+		// the reservation has just expired, the client may retry
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	var record idempotencyRecord
+	if err := json.Unmarshal(value, &record); err != nil {
+		return nil, "", err
+	}
+	if record.BodyHash != reservation.record.BodyHash {
+		return nil, "", models.ErrIdempotencyKeyReused
+	}
+	return nil, record.PostId, nil
+}
+
+// Complete stores the post created by the request that reserved the key
+func (s *IdempotencyStorage) Complete(reservation *IdempotencyReservation, postId models.PostID) error {
+	completed, err := json.Marshal(idempotencyRecord{
+		BodyHash: reservation.record.BodyHash,
+		PostId:   postId,
+	})
+	if err != nil {
+		return err
+	}
+	keys := []string{reservation.redisKey}
+	return completeScript.Run(context.TODO(), s.client, keys, reservation.record.Token, completed, s.ttl.Milliseconds()).Err()
+}
+
+// Release frees the key after a failed request, so that it can be retried
+func (s *IdempotencyStorage) Release(reservation *IdempotencyReservation) error {
+	keys := []string{reservation.redisKey}
+	return releaseScript.Run(context.TODO(), s.client, keys, reservation.record.Token).Err()
+}
+
+// redisKey prefixes the user with its length, as both the user and the key
+// may contain the separator
+func (s *IdempotencyStorage) redisKey(userId models.UserID, key string) string {
+	return fmt.Sprintf("idempotency:%d:%s:%s", len(userId), userId, key)
+}
+
+func NewIdempotencyStorage(client *redis.Client, ttl time.Duration) *IdempotencyStorage {
+	return &IdempotencyStorage{
+		client: client,
+		ttl:    ttl,
+	}
+}
@@ -2,18 +2,29 @@ package storage
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"sync"
 
 	"github.com/ikolcov/microblog/internal/models"
+	"github.com/ikolcov/microblog/internal/utils"
 )
 
 type InMemoryStorage struct {
-	posts         []models.Post
-	postsByUser   map[models.UserID][]int
-	subscriptions map[models.UserID][]models.UserID
-	subscribers   map[models.UserID][]models.UserID
-	mutex         sync.RWMutex
+	posts          []models.Post
+	postsByUser    map[models.UserID][]int
+	repliesByPost  map[models.PostID][]int
+	repostsByPost  map[models.PostID][]int
+	postsByTag     map[string][]int
+	postsByMention map[models.UserID][]int
+	postsByWord    map[string][]int
+	revisions      map[models.PostID][]models.Revision
+	deletedPosts   map[int]bool
+	likes          map[models.PostID]map[models.UserID]bool
+	subscriptions  map[models.UserID][]models.UserID
+	subscribers    map[models.UserID][]models.UserID
+	blocks         map[models.UserID]map[models.UserID]bool
+	mutex          sync.RWMutex
 }
 
 // func (s *InMemoryStorage) feed(userId models.UserID) {
@@ -39,11 +50,57 @@ func (s *InMemoryStorage) AddPost(post models.Post) (models.PostID, error) {
 	if post.AuthorId == "" {
 		return *new(models.PostID), models.ErrUnauthorized
 	}
+	// posts the user may not see cannot be replied to, quoted or reposted
+	if post.InReplyTo != "" {
+		// a reply to a repost is a reply to the original post
+		parent, err := s.getVisiblePost(post.AuthorId, post.InReplyTo)
+		if err != nil {
+			return *new(models.PostID), models.ErrBadRequest
+		}
+		post.InReplyTo = parent.Id
+	}
+	if post.QuotedPostId != "" {
+		quoted, err := s.getVisiblePost(post.AuthorId, post.QuotedPostId)
+		if err != nil {
+			return *new(models.PostID), models.ErrBadRequest
+		}
+		post.QuotedPostId = quoted.Id
+	}
+	if post.RepostOf != "" {
+		// a repost of a repost points at the original post
+		original, err := s.getVisiblePost(post.AuthorId, post.RepostOf)
+		if err != nil {
+			return *new(models.PostID), err
+		}
+		post.RepostOf = original.Id
+		for _, id := range s.repostsByPost[post.RepostOf] {
+			if s.posts[id].AuthorId == post.AuthorId {
+				// the user has already reposted the post
+				return s.posts[id].Id, nil
+			}
+		}
+	}
 
 	id := len(s.posts)
 	post.Id = models.PostID(fmt.Sprint(id))
+	post.Version = 1
 	s.posts = append(s.posts, post)
 	s.postsByUser[post.AuthorId] = append(s.postsByUser[post.AuthorId], id)
+	if post.InReplyTo != "" {
+		s.repliesByPost[post.InReplyTo] = append(s.repliesByPost[post.InReplyTo], id)
+	}
+	if post.RepostOf != "" {
+		s.repostsByPost[post.RepostOf] = append(s.repostsByPost[post.RepostOf], id)
+	}
+	for _, tag := range post.Tags {
+		s.postsByTag[tag] = append(s.postsByTag[tag], id)
+	}
+	for _, userId := range post.Mentions {
+		s.postsByMention[userId] = append(s.postsByMention[userId], id)
+	}
+	for _, word := range distinctWords(post.Text) {
+		s.postsByWord[word] = append(s.postsByWord[word], id)
+	}
 
 	return post.Id, nil
 }
@@ -52,75 +109,544 @@ func (s *InMemoryStorage) GetPost(postId models.PostID) (models.Post, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
+	return s.getPost(postId)
+}
+
+// getPost is GetPost for callers already holding the lock
+func (s *InMemoryStorage) getPost(postId models.PostID) (models.Post, error) {
 	id, err := strconv.Atoi(string(postId))
-	if err != nil || id < 0 || id >= len(s.posts) {
+	if err != nil || id < 0 || id >= len(s.posts) || s.deletedPosts[id] {
 		return *new(models.Post), models.ErrNotFound
 	}
 	post := s.posts[id]
 	return post, nil
 }
 
+func (s *InMemoryStorage) GetVisiblePost(userId models.UserID, postId models.PostID) (models.Post, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.getVisiblePost(userId, postId)
+}
+
+// getVisiblePost is GetVisiblePost for callers already holding the lock
+func (s *InMemoryStorage) getVisiblePost(userId models.UserID, postId models.PostID) (models.Post, error) {
+	post, err := s.getPost(postId)
+	if err != nil {
+		return post, err
+	}
+	if post.RepostOf != "" {
+		if post, err = s.getPost(post.RepostOf); err != nil {
+			return post, err
+		}
+	}
+	if !s.canSeePosts(userId, post.AuthorId) {
+		return *new(models.Post), models.ErrNotFound
+	}
+	return post, nil
+}
+
 func (s *InMemoryStorage) UpdatePost(postUpdate models.Post) (models.Post, error) {
 	if postUpdate.AuthorId == "" {
 		return *new(models.Post), models.ErrUnauthorized
 	}
-	post, err := s.GetPost(postUpdate.Id)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	post, err := s.getPost(postUpdate.Id)
 	if err != nil {
 		return *new(models.Post), err
 	}
 	if post.AuthorId != postUpdate.AuthorId {
 		return *new(models.Post), models.ErrFobidden
 	}
+	if post.RepostOf != "" {
+		return *new(models.Post), models.ErrBadRequest
+	}
+	if postUpdate.Version != 0 && postUpdate.Version != post.Version {
+		return *new(models.Post), models.ErrPreconditionFailed
+	}
 
+	revision := models.Revision{
+		PostId:         post.Id,
+		Text:           post.Text,
+		LastModifiedAt: post.LastModifiedAt,
+	}
+	oldText, oldTags, oldMentions := post.Text, post.Tags, post.Mentions
 	post.Text = postUpdate.Text
+	post.Tags = postUpdate.Tags
+	post.Mentions = postUpdate.Mentions
 	post.LastModifiedAt = postUpdate.LastModifiedAt
-
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	post.Edited = true
+	post.EditCount++
+	post.Version++
 
 	id, _ := strconv.Atoi(string(post.Id))
 	s.posts[id] = post
+	s.revisions[post.Id] = append(s.revisions[post.Id], revision)
+	for _, tag := range oldTags {
+		s.postsByTag[tag] = removeId(s.postsByTag[tag], id)
+	}
+	for _, tag := range post.Tags {
+		s.postsByTag[tag] = insertId(s.postsByTag[tag], id)
+	}
+	for _, userId := range oldMentions {
+		s.postsByMention[userId] = removeId(s.postsByMention[userId], id)
+	}
+	for _, userId := range post.Mentions {
+		s.postsByMention[userId] = insertId(s.postsByMention[userId], id)
+	}
+	for _, word := range distinctWords(oldText) {
+		s.postsByWord[word] = removeId(s.postsByWord[word], id)
+	}
+	for _, word := range distinctWords(post.Text) {
+		s.postsByWord[word] = insertId(s.postsByWord[word], id)
+	}
 
 	return post, nil
 }
 
-func (s *InMemoryStorage) GetUserPosts(userId models.UserID, page int, size int) (models.PostsPage, error) {
+func (s *InMemoryStorage) GetRevisions(postId models.PostID) (models.RevisionsList, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if _, err := s.getPost(postId); err != nil {
+		return *new(models.RevisionsList), err
+	}
+	return models.RevisionsList{
+		Revisions: append(make([]models.Revision, 0), s.revisions[postId]...),
+	}, nil
+}
+
+func (s *InMemoryStorage) GetUserPosts(userId models.UserID, page models.PageRequest) (models.PostsPage, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.getPostsPage(s.postsByUser[userId], page)
+}
+
+func (s *InMemoryStorage) GetReplies(postId models.PostID, page models.PageRequest) (models.PostsPage, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if _, err := s.getPost(postId); err != nil {
+		return *new(models.PostsPage), err
+	}
+	return s.getPostsPage(s.repliesByPost[postId], page)
+}
+
+func (s *InMemoryStorage) GetTagPosts(tag string, page models.PageRequest) (models.PostsPage, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.getPostsPage(s.postsByTag[tag], page)
+}
+
+func (s *InMemoryStorage) GetMentions(userId models.UserID, page models.PageRequest) (models.PostsPage, error) {
+	if userId == "" {
+		return *new(models.PostsPage), models.ErrUnauthorized
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.getPostsPage(s.postsByMention[userId], page)
+}
+
+// SearchPosts finds posts containing any of the words of the query. The
+// relevance of a post is the number of occurrences of the words in it.
+func (s *InMemoryStorage) SearchPosts(query models.SearchQuery, page models.PageRequest) (models.PostsPage, error) {
+	terms := distinctWords(query.Text)
+	if len(terms) == 0 {
+		return *new(models.PostsPage), models.ErrBadRequest
+	}
+
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	posts := s.postsByUser[userId]
+	isTerm := make(map[string]bool)
+	for _, term := range terms {
+		isTerm[term] = true
+	}
+	scores := make(map[int]float64)
+	for _, term := range terms {
+		for _, id := range s.postsByWord[term] {
+			if _, found := scores[id]; found {
+				continue
+			}
+			post := s.posts[id]
+			if query.AuthorId != "" && post.AuthorId != query.AuthorId ||
+				!query.Since.IsZero() && post.CreatedTime.Before(query.Since) ||
+				!query.Until.IsZero() && !post.CreatedTime.Before(query.Until) {
+				continue
+			}
+			score := 0.0
+			for _, word := range utils.SplitWords(post.Text) {
+				if isTerm[word] {
+					score++
+				}
+			}
+			scores[id] = score
+		}
+	}
+
+	postIds := make([]int, 0, len(scores))
+	for id := range scores {
+		postIds = append(postIds, id)
+	}
+	if query.Sort == models.SearchSortRecent {
+		sort.Ints(postIds)
+		return s.getPostsPage(postIds, page)
+	}
+
+	sort.Slice(postIds, func(i, j int) bool {
+		if scores[postIds[i]] != scores[postIds[j]] {
+			return scores[postIds[i]] > scores[postIds[j]]
+		}
+		return postIds[i] > postIds[j]
+	})
+
+	from := page.Offset()
+	if page.After != nil {
+		lastId, err := strconv.Atoi(string(page.After.Id))
+		if err != nil {
+			return *new(models.PostsPage), models.ErrBadRequest
+		}
+		from = sort.Search(len(postIds), func(i int) bool {
+			score := scores[postIds[i]]
+			return score < page.After.Score || score == page.After.Score && postIds[i] < lastId
+		})
+	}
+	if from > len(postIds) {
+		return *new(models.PostsPage), models.ErrBadRequest
+	}
+	to := from + page.Size
+	if to > len(postIds) {
+		to = len(postIds)
+	}
+
+	postsPage := models.PostsPage{
+		Posts: make([]models.Post, 0),
+	}
+	for _, id := range postIds[from:to] {
+		postsPage.Posts = append(postsPage.Posts, s.posts[id])
+	}
+	if to < len(postIds) {
+		last := s.posts[postIds[to-1]]
+		postsPage.Next = &models.Cursor{Id: last.Id, Time: last.CreatedTime, Score: scores[postIds[to-1]]}
+	}
+
+	return postsPage, nil
+}
+
+func distinctWords(text string) []string {
+	seen := make(map[string]bool)
+	words := make([]string, 0)
+	for _, word := range utils.SplitWords(text) {
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+func (s *InMemoryStorage) GetThread(postId models.PostID, page models.PageRequest) (models.Thread, error) {
+	return getThread(s, postId, page)
+}
+
+// getPostsPage returns a page of posts newest first, given their ids in the
+// order the posts were added. The caller must hold the lock.
+func (s *InMemoryStorage) getPostsPage(posts []int, page models.PageRequest) (models.PostsPage, error) {
 	postIds := make([]int, 0)
 	for i := len(posts) - 1; i >= 0; i-- {
 		postIds = append(postIds, posts[i])
 	}
 
-	from := (page - 1) * size
+	from := page.Offset()
+	if page.After != nil {
+		lastId, err := strconv.Atoi(string(page.After.Id))
+		if err != nil {
+			return *new(models.PostsPage), models.ErrBadRequest
+		}
+		from = sort.Search(len(postIds), func(i int) bool {
+			return postIds[i] < lastId
+		})
+	}
 	if from < 0 || from > len(postIds) {
 		return *new(models.PostsPage), models.ErrBadRequest
 	}
-	to := from + size
+	to := from + page.Size
 	if to > len(postIds) {
 		to = len(postIds)
 	}
 
-	postIds = postIds[from:to]
-
 	postsPage := models.PostsPage{
 		Posts: make([]models.Post, 0),
 	}
-	for _, id := range postIds {
+	for _, id := range postIds[from:to] {
 		postsPage.Posts = append(postsPage.Posts, s.posts[id])
 	}
-	if to < len(posts) {
-		postsPage.NextPage = fmt.Sprint(page + 1)
+	if to < len(postIds) {
+		last := s.posts[postIds[to-1]]
+		postsPage.Next = &models.Cursor{Id: last.Id, Time: last.CreatedTime}
 	}
 
 	return postsPage, nil
 }
 
+func (s *InMemoryStorage) DeletePost(postId models.PostID, userId models.UserID) error {
+	if userId == "" {
+		return models.ErrUnauthorized
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	post, err := s.getPost(postId)
+	if err != nil {
+		return err
+	}
+	if post.AuthorId != userId {
+		return models.ErrFobidden
+	}
+
+	// deletePost removes reposts from the index, so it is copied first
+	reposts := append([]int(nil), s.repostsByPost[postId]...)
+	for _, repostId := range reposts {
+		s.deletePost(repostId)
+	}
+	id, _ := strconv.Atoi(string(post.Id))
+	s.deletePost(id)
+
+	return nil
+}
+
+func (s *InMemoryStorage) DeleteRepost(postId models.PostID, userId models.UserID) (models.PostID, error) {
+	if userId == "" {
+		return *new(models.PostID), models.ErrUnauthorized
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	post, err := s.getPost(postId)
+	if err != nil {
+		return *new(models.PostID), err
+	}
+	if post.RepostOf != "" {
+		postId = post.RepostOf
+	}
+	for _, id := range s.repostsByPost[postId] {
+		if s.posts[id].AuthorId == userId {
+			s.deletePost(id)
+			return s.posts[id].Id, nil
+		}
+	}
+	return *new(models.PostID), models.ErrNotFound
+}
+
+func (s *InMemoryStorage) deletePost(id int) {
+	post := s.posts[id]
+	s.deletedPosts[id] = true
+
+	s.postsByUser[post.AuthorId] = removeId(s.postsByUser[post.AuthorId], id)
+	if post.InReplyTo != "" {
+		s.repliesByPost[post.InReplyTo] = removeId(s.repliesByPost[post.InReplyTo], id)
+	}
+	if post.RepostOf != "" {
+		s.repostsByPost[post.RepostOf] = removeId(s.repostsByPost[post.RepostOf], id)
+	}
+	for _, tag := range post.Tags {
+		s.postsByTag[tag] = removeId(s.postsByTag[tag], id)
+	}
+	for _, userId := range post.Mentions {
+		s.postsByMention[userId] = removeId(s.postsByMention[userId], id)
+	}
+	for _, word := range distinctWords(post.Text) {
+		s.postsByWord[word] = removeId(s.postsByWord[word], id)
+	}
+	delete(s.revisions, post.Id)
+	delete(s.likes, post.Id)
+}
+
+// insertId keeps ids sorted in the order the posts were added
+func insertId(ids []int, id int) []int {
+	i := sort.SearchInts(ids, id)
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
+
+func removeId(ids []int, id int) []int {
+	for i, elem := range ids {
+		if elem == id {
+			return append(ids[:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
+
+func (s *InMemoryStorage) LikePost(postId models.PostID, userId models.UserID) error {
+	return s.setLike(postId, userId, true)
+}
+
+func (s *InMemoryStorage) UnlikePost(postId models.PostID, userId models.UserID) error {
+	return s.setLike(postId, userId, false)
+}
+
+func (s *InMemoryStorage) setLike(postId models.PostID, userId models.UserID, liked bool) error {
+	if userId == "" {
+		return models.ErrUnauthorized
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	post, err := s.getPost(postId)
+	if err != nil {
+		return err
+	}
+	if post.RepostOf != "" {
+		// likes of a repost count towards the reposted post
+		if post, err = s.getPost(post.RepostOf); err != nil {
+			return err
+		}
+		postId = post.Id
+	}
+	// a like may still be taken back from a post the user may not see anymore
+	if liked && !s.canSeePosts(userId, post.AuthorId) {
+		return models.ErrNotFound
+	}
+	if s.likes[postId][userId] == liked {
+		return nil
+	}
+	if s.likes[postId] == nil {
+		s.likes[postId] = make(map[models.UserID]bool)
+	}
+	s.likes[postId][userId] = liked
+
+	id, _ := strconv.Atoi(string(post.Id))
+	if liked {
+		s.posts[id].LikesCount++
+	} else {
+		s.posts[id].LikesCount--
+	}
+
+	return nil
+}
+
+func (s *InMemoryStorage) BlockUser(userId models.UserID, blockedId models.UserID) error {
+	if userId == "" {
+		return models.ErrUnauthorized
+	}
+	if blockedId == "" || userId == blockedId {
+		return models.ErrBadRequest
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.blocks[userId] == nil {
+		s.blocks[userId] = make(map[models.UserID]bool)
+	}
+	s.blocks[userId][blockedId] = true
+
+	s.subscriptions[userId] = removeUser(s.subscriptions[userId], blockedId)
+	s.subscribers[blockedId] = removeUser(s.subscribers[blockedId], userId)
+	s.subscriptions[blockedId] = removeUser(s.subscriptions[blockedId], userId)
+	s.subscribers[userId] = removeUser(s.subscribers[userId], blockedId)
+
+	return nil
+}
+
+func (s *InMemoryStorage) UnblockUser(userId models.UserID, blockedId models.UserID) error {
+	if userId == "" {
+		return models.ErrUnauthorized
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.blocks[userId], blockedId)
+	return nil
+}
+
+func (s *InMemoryStorage) IsBlocked(userId models.UserID, otherId models.UserID) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.blocks[userId][otherId], nil
+}
+
+func (s *InMemoryStorage) CanSeePosts(viewerId models.UserID, authorId models.UserID) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.canSeePosts(viewerId, authorId), nil
+}
+
+// canSeePosts is CanSeePosts for callers already holding the lock. Users
+// blocked by the author may not see the posts.
+func (s *InMemoryStorage) canSeePosts(viewerId models.UserID, authorId models.UserID) bool {
+	return viewerId == authorId || !s.blocks[authorId][viewerId]
+}
+
+func removeUser(users []models.UserID, userId models.UserID) []models.UserID {
+	for i, elem := range users {
+		if elem == userId {
+			return append(users[:i], users[i+1:]...)
+		}
+	}
+	return users
+}
+
+func (s *InMemoryStorage) DecoratePosts(viewerId models.UserID, posts []models.Post) ([]models.Post, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	resolved := make([]models.Post, 0, len(posts))
+	for _, post := range posts {
+		if post.RepostOf != "" {
+			original, err := s.getPost(post.RepostOf)
+			if err != nil {
+				continue
+			}
+			original.RepostedBy = post.AuthorId
+			post = original
+		}
+		if s.canSeePosts(viewerId, post.AuthorId) {
+			resolved = append(resolved, post)
+		}
+	}
+	posts = resolved
+
+	for i := range posts {
+		posts[i].QuotedPost = nil
+		if quoted, err := s.getVisiblePost(viewerId, posts[i].QuotedPostId); posts[i].QuotedPostId != "" && err == nil {
+			posts[i].QuotedPost = models.NewQuotedPost(quoted)
+		}
+		posts[i].LikedByMe = s.likes[posts[i].Id][viewerId]
+	}
+	return posts, nil
+}
+
 func NewInMemoryStorage() Storage {
 	return &InMemoryStorage{
-		posts:       make([]models.Post, 0),
-		postsByUser: make(map[models.UserID][]int),
+		posts:          make([]models.Post, 0),
+		postsByUser:    make(map[models.UserID][]int),
+		repliesByPost:  make(map[models.PostID][]int),
+		repostsByPost:  make(map[models.PostID][]int),
+		postsByTag:     make(map[string][]int),
+		postsByMention: make(map[models.UserID][]int),
+		postsByWord:    make(map[string][]int),
+		revisions:      make(map[models.PostID][]models.Revision),
+		deletedPosts:   make(map[int]bool),
+		likes:          make(map[models.PostID]map[models.UserID]bool),
+		subscriptions:  make(map[models.UserID][]models.UserID),
+		subscribers:    make(map[models.UserID][]models.UserID),
+		blocks:         make(map[models.UserID]map[models.UserID]bool),
 	}
 }
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ikolcov/microblog/internal/models"
+)
+
+func newSearchStorage(t *testing.T) (Storage, time.Time) {
+	s := NewInMemoryStorage()
+	start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, post := range []models.Post{
+		{AuthorId: "alice", Text: "Go is fun"},
+		{AuthorId: "bob", Text: "go, go, GO!"},
+		{AuthorId: "alice", Text: "Rust is fun"},
+		{AuthorId: "bob", Text: "Go and Rust"},
+	} {
+		post.CreatedTime = start.Add(time.Duration(i) * time.Hour)
+		if _, err := s.AddPost(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, start
+}
+
+func postIds(posts []models.Post) []string {
+	ids := make([]string, 0, len(posts))
+	for _, post := range posts {
+		ids = append(ids, string(post.Id))
+	}
+	return ids
+}
+
+func TestInMemorySearchPosts(t *testing.T) {
+	s, start := newSearchStorage(t)
+
+	tests := []struct {
+		name  string
+		query models.SearchQuery
+		want  []string
+	}{
+		{"relevance", models.SearchQuery{Text: "go"}, []string{"1", "3", "0"}},
+		{"recent", models.SearchQuery{Text: "go", Sort: models.SearchSortRecent}, []string{"3", "1", "0"}},
+		{"any of the words", models.SearchQuery{Text: "go rust"}, []string{"1", "3", "2", "0"}},
+		{"case and punctuation", models.SearchQuery{Text: "RUST!"}, []string{"3", "2"}},
+		{"author", models.SearchQuery{Text: "fun", AuthorId: "alice"}, []string{"2", "0"}},
+		{"since and until", models.SearchQuery{Text: "go", Since: start.Add(time.Hour), Until: start.Add(3 * time.Hour)}, []string{"1"}},
+		{"no matches", models.SearchQuery{Text: "python"}, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			postsPage, err := s.SearchPosts(test.query, models.PageRequest{Size: 10})
+			if err != nil {
+				t.Fatalf("SearchPosts() error = %v", err)
+			}
+			if got := postIds(postsPage.Posts); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("SearchPosts() = %q, want %q", got, test.want)
+			}
+			if postsPage.Next != nil {
+				t.Errorf("SearchPosts() next = %+v, want none", postsPage.Next)
+			}
+		})
+	}
+}
+
+func TestInMemorySearchPostsPages(t *testing.T) {
+	s, _ := newSearchStorage(t)
+
+	for _, sort := range []string{models.SearchSortRelevance, models.SearchSortRecent} {
+		t.Run(sort, func(t *testing.T) {
+			query := models.SearchQuery{Text: "go rust", Sort: sort}
+			all, err := s.SearchPosts(query, models.PageRequest{Size: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			first, err := s.SearchPosts(query, models.PageRequest{Size: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first.Next == nil {
+				t.Fatal("first page has no next page")
+			}
+			second, err := s.SearchPosts(query, models.PageRequest{After: first.Next, Size: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := postIds(append(first.Posts, second.Posts...)), postIds(all.Posts); !reflect.DeepEqual(got, want) {
+				t.Errorf("cursor pages = %q, want %q", got, want)
+			}
+			if second.Next != nil {
+				t.Errorf("last page next = %+v, want none", second.Next)
+			}
+
+			numeric, err := s.SearchPosts(query, models.PageRequest{Page: 2, Size: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := postIds(numeric.Posts), postIds(second.Posts); !reflect.DeepEqual(got, want) {
+				t.Errorf("numeric page = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestInMemorySearchPostsInvalidQueries(t *testing.T) {
+	s, _ := newSearchStorage(t)
+
+	tests := []struct {
+		name  string
+		query models.SearchQuery
+		page  models.PageRequest
+	}{
+		{"empty", models.SearchQuery{Text: ""}, models.PageRequest{Size: 10}},
+		{"punctuation only", models.SearchQuery{Text: "?!"}, models.PageRequest{Size: 10}},
+		{"invalid cursor", models.SearchQuery{Text: "go"}, models.PageRequest{After: &models.Cursor{Id: "x"}, Size: 10}},
+		{"page past the end", models.SearchQuery{Text: "go"}, models.PageRequest{Page: 5, Size: 10}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := s.SearchPosts(test.query, test.page); err != models.ErrBadRequest {
+				t.Errorf("SearchPosts() error = %v, want %v", err, models.ErrBadRequest)
+			}
+		})
+	}
+}
+
+func TestInMemorySearchPostsFollowsEdits(t *testing.T) {
+	s, _ := newSearchStorage(t)
+
+	if _, err := s.UpdatePost(models.Post{Id: "0", AuthorId: "alice", Text: "Python is fun"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeletePost("3", "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	for text, want := range map[string][]string{
+		"go":     {"1"},
+		"python": {"0"},
+	} {
+		postsPage, err := s.SearchPosts(models.SearchQuery{Text: text}, models.PageRequest{Size: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := postIds(postsPage.Posts); !reflect.DeepEqual(got, want) {
+			t.Errorf("SearchPosts(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestInMemoryBlockedUserInteractions(t *testing.T) {
+	s := NewInMemoryStorage()
+	postId, err := s.AddPost(models.Post{AuthorId: "alice", Text: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repostId, err := s.AddPost(models.Post{AuthorId: "carol", RepostOf: postId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.BlockUser("alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		act     func() error
+		wantErr error
+	}{
+		{"reply", func() error {
+			_, err := s.AddPost(models.Post{AuthorId: "bob", InReplyTo: postId})
+			return err
+		}, models.ErrBadRequest},
+		{"quote", func() error {
+			_, err := s.AddPost(models.Post{AuthorId: "bob", QuotedPostId: postId})
+			return err
+		}, models.ErrBadRequest},
+		{"repost", func() error {
+			_, err := s.AddPost(models.Post{AuthorId: "bob", RepostOf: repostId})
+			return err
+		}, models.ErrNotFound},
+		{"like", func() error { return s.LikePost(repostId, "bob") }, models.ErrNotFound},
+		{"unlike", func() error { return s.UnlikePost(postId, "bob") }, nil},
+		{"reply by another user", func() error {
+			_, err := s.AddPost(models.Post{AuthorId: "carol", InReplyTo: postId})
+			return err
+		}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.act(); err != test.wantErr {
+				t.Errorf("error = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+
+	quoteId, err := s.AddPost(models.Post{AuthorId: "carol", QuotedPostId: postId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	quote, err := s.GetPost(quoteId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts, err := s.DecoratePosts("bob", []models.Post{quote, {Id: repostId, AuthorId: "carol", RepostOf: postId}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := postIds(posts); !reflect.DeepEqual(got, []string{string(quoteId)}) {
+		t.Errorf("DecoratePosts() = %q, want %q", got, []string{string(quoteId)})
+	}
+	if len(posts) > 0 && posts[0].QuotedPost != nil {
+		t.Errorf("DecoratePosts() embeds the quoted post of the blocking user")
+	}
+	if _, err := s.GetVisiblePost("bob", repostId); err != models.ErrNotFound {
+		t.Errorf("GetVisiblePost() error = %v, want %v", err, models.ErrNotFound)
+	}
+}
+
+func TestInMemoryReposts(t *testing.T) {
+	s := NewInMemoryStorage()
+	postId, err := s.AddPost(models.Post{AuthorId: "alice", Text: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repostId, err := s.AddPost(models.Post{AuthorId: "bob", RepostOf: postId})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replyId, err := s.AddPost(models.Post{AuthorId: "carol", InReplyTo: repostId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := s.GetPost(replyId); err != nil || reply.InReplyTo != postId {
+		t.Errorf("reply to a repost is in reply to %q, want %q", reply.InReplyTo, postId)
+	}
+
+	if _, err := s.DeleteRepost(postId, "carol"); err != models.ErrNotFound {
+		t.Errorf("DeleteRepost() of a post not reposted error = %v, want %v", err, models.ErrNotFound)
+	}
+	deletedId, err := s.DeleteRepost(postId, "bob")
+	if err != nil || deletedId != repostId {
+		t.Fatalf("DeleteRepost() = %q, %v, want %q", deletedId, err, repostId)
+	}
+	if _, err := s.GetPost(repostId); err != models.ErrNotFound {
+		t.Errorf("GetPost() of the removed repost error = %v, want %v", err, models.ErrNotFound)
+	}
+	if _, err := s.GetPost(postId); err != nil {
+		t.Errorf("GetPost() of the original post error = %v", err)
+	}
+}
@@ -3,10 +3,10 @@ package storage
 import (
 	"context"
 	"errors"
-	"fmt"
 	"log"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/ikolcov/microblog/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,15 +15,38 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const fanoutBatchSize = 500
+
+type FeedConfig struct {
+	// MaxLength is the number of the newest posts kept in a materialized feed
+	MaxLength int
+	// FanoutThreshold is the number of followers starting from which posts
+	// of an author are not pushed into feeds. Zero disables the limit.
+	FanoutThreshold int
+}
+
 type MongoStorage struct {
-	posts         *mongo.Collection
-	subscriptions *mongo.Collection
-	feed          *mongo.Collection
+	posts          *mongo.Collection
+	subscriptions  *mongo.Collection
+	feed           *mongo.Collection
+	followers      *mongo.Collection
+	likes          *mongo.Collection
+	notifications  *mongo.Collection
+	revisions      *mongo.Collection
+	users          *mongo.Collection
+	blocks         *mongo.Collection
+	mutes          *mongo.Collection
+	followRequests *mongo.Collection
+	feedConfig     FeedConfig
 }
 
-func addIndex(collection *mongo.Collection, field string) {
+func addIndex(collection *mongo.Collection, fields ...string) {
+	keys := bson.D{}
+	for _, field := range fields {
+		keys = append(keys, bson.E{field, 1})
+	}
 	index := mongo.IndexModel{
-		Keys: bson.D{{field, 1}},
+		Keys: keys,
 	}
 	_, err := collection.Indexes().CreateOne(context.TODO(), index)
 	if err != nil {
@@ -35,159 +58,1556 @@ func (s *MongoStorage) AddPost(post models.Post) (models.PostID, error) {
 	if post.AuthorId == "" {
 		return *new(models.PostID), models.ErrUnauthorized
 	}
+	// posts the user may not see cannot be replied to, quoted or reposted
+	if post.InReplyTo != "" {
+		// a reply to a repost is a reply to the original post
+		parent, err := s.GetVisiblePost(post.AuthorId, post.InReplyTo)
+		if errors.Is(err, models.ErrNotFound) {
+			return *new(models.PostID), models.ErrBadRequest
+		} else if err != nil {
+			return *new(models.PostID), err
+		}
+		post.InReplyTo = parent.Id
+	}
+	if post.QuotedPostId != "" {
+		quoted, err := s.GetVisiblePost(post.AuthorId, post.QuotedPostId)
+		if errors.Is(err, models.ErrNotFound) {
+			return *new(models.PostID), models.ErrBadRequest
+		} else if err != nil {
+			return *new(models.PostID), err
+		}
+		post.QuotedPostId = quoted.Id
+	}
+	if post.RepostOf != "" {
+		// a repost of a repost points at the original post
+		original, err := s.GetVisiblePost(post.AuthorId, post.RepostOf)
+		if err != nil {
+			return *new(models.PostID), err
+		}
+		post.RepostOf = original.Id
+	}
+
+	post.Version = 1
+	insertResult, err := s.posts.InsertOne(context.TODO(), post)
+	if err != nil && post.RepostOf != "" && strings.Contains(err.Error(), "duplicate") {
+		// the user has already reposted the post
+		var repost models.HexId
+		filter := bson.D{{"authorid", post.AuthorId}, {"repostof", post.RepostOf}}
+		if err := s.posts.FindOne(context.TODO(), filter).Decode(&repost); err != nil {
+			return *new(models.PostID), err
+		}
+		return models.PostID(repost.ID.Hex()), nil
+	}
+	if err != nil {
+		return *new(models.PostID), err
+	}
+
+	return models.PostID(insertResult.InsertedID.(primitive.ObjectID).Hex()), nil
+}
+
+func (s *MongoStorage) GetPost(postId models.PostID) (models.Post, error) {
+	id, err := primitive.ObjectIDFromHex(string(postId))
+	if err != nil {
+		return *new(models.Post), models.ErrNotFound
+	}
+
+	var result models.Post
+	err = s.posts.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return *new(models.Post), models.ErrNotFound
+	}
+	result.Id = postId
+	if result.Version == 0 {
+		result.Version = 1
+	}
+	return result, err
+}
+
+func (s *MongoStorage) GetVisiblePost(userId models.UserID, postId models.PostID) (models.Post, error) {
+	post, err := s.GetPost(postId)
+	if err != nil {
+		return post, err
+	}
+	if post.RepostOf != "" {
+		if post, err = s.GetPost(post.RepostOf); err != nil {
+			return post, err
+		}
+	}
+	visible, err := s.CanSeePosts(userId, post.AuthorId)
+	if err != nil {
+		return *new(models.Post), err
+	}
+	if !visible {
+		return *new(models.Post), models.ErrNotFound
+	}
+	return post, nil
+}
+
+// versionFilter matches the given version of a post, a post without a
+// version is at the first one
+func versionFilter(version int64) bson.E {
+	if version == 1 {
+		return bson.E{"version", bson.D{{"$in", bson.A{1, nil}}}}
+	}
+	return bson.E{"version", version}
+}
+
+func (s *MongoStorage) UpdatePost(postUpdate models.Post) (models.Post, error) {
+	if postUpdate.AuthorId == "" {
+		return *new(models.Post), models.ErrUnauthorized
+	}
+	post, err := s.GetPost(postUpdate.Id)
+	if err != nil {
+		return *new(models.Post), err
+	}
+	if post.AuthorId != postUpdate.AuthorId {
+		return *new(models.Post), models.ErrFobidden
+	}
+	if post.RepostOf != "" {
+		return *new(models.Post), models.ErrBadRequest
+	}
+	// the version of the update is the one the client expects, if any
+	if postUpdate.Version != 0 && postUpdate.Version != post.Version {
+		return *new(models.Post), models.ErrPreconditionFailed
+	}
+
+	// the update only applies to the version read above, so an edit made in
+	// between is not overwritten
+	id, _ := primitive.ObjectIDFromHex(string(postUpdate.Id))
+	filter := bson.D{{"_id", id}, versionFilter(post.Version)}
+	update := bson.D{
+		{"$set", bson.D{
+			{"text", postUpdate.Text},
+			{"tags", postUpdate.Tags},
+			{"mentions", postUpdate.Mentions},
+			{"lastmodifiedat", postUpdate.LastModifiedAt},
+			{"edited", true},
+			{"version", post.Version + 1},
+		}},
+		{"$inc", bson.D{{"editcount", 1}}},
+	}
+
+	var previous models.Post
+	err = s.posts.FindOneAndUpdate(context.TODO(), filter, update).Decode(&previous)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// the post has been either deleted or edited since it was read
+		if _, err := s.GetPost(postUpdate.Id); err != nil {
+			return *new(models.Post), err
+		}
+		if postUpdate.Version != 0 {
+			return *new(models.Post), models.ErrPreconditionFailed
+		}
+		return *new(models.Post), models.ErrConflict
+	} else if err != nil {
+		return *new(models.Post), err
+	}
+	_, err = s.revisions.InsertOne(context.TODO(), models.Revision{
+		PostId:         post.Id,
+		Text:           previous.Text,
+		LastModifiedAt: previous.LastModifiedAt,
+	})
+	if err != nil {
+		return *new(models.Post), err
+	}
+
+	post = previous
+	post.Id = postUpdate.Id
+	post.Text = postUpdate.Text
+	post.Tags = postUpdate.Tags
+	post.Mentions = postUpdate.Mentions
+	post.LastModifiedAt = postUpdate.LastModifiedAt
+	post.Edited = true
+	post.EditCount++
+	post.Version++
+	return post, nil
+}
+
+func (s *MongoStorage) GetRevisions(postId models.PostID) (models.RevisionsList, error) {
+	if _, err := s.GetPost(postId); err != nil {
+		return *new(models.RevisionsList), err
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{"_id", 1}})
+	cur, err := s.revisions.Find(context.TODO(), bson.D{{"postid", postId}}, findOptions)
+	if err != nil {
+		return *new(models.RevisionsList), err
+	}
+	defer cur.Close(context.TODO())
+
+	revisions := models.RevisionsList{
+		Revisions: make([]models.Revision, 0),
+	}
+	for cur.Next(context.TODO()) {
+		var elem models.Revision
+		if err := cur.Decode(&elem); err != nil {
+			return *new(models.RevisionsList), err
+		}
+		revisions.Revisions = append(revisions.Revisions, elem)
+	}
+	if err := cur.Err(); err != nil {
+		return *new(models.RevisionsList), err
+	}
+
+	return revisions, nil
+}
+
+func (s *MongoStorage) DeletePost(postId models.PostID, userId models.UserID) error {
+	if userId == "" {
+		return models.ErrUnauthorized
+	}
+	post, err := s.GetPost(postId)
+	if err != nil {
+		return err
+	}
+	if post.AuthorId != userId {
+		return models.ErrFobidden
+	}
+
+	reposts, err := s.posts.Distinct(context.TODO(), "_id", bson.D{{"repostof", postId}})
+	if err != nil {
+		return err
+	}
+	postIds := bson.A{postId}
+	for _, repostId := range reposts {
+		if repostId, ok := repostId.(primitive.ObjectID); ok {
+			postIds = append(postIds, models.PostID(repostId.Hex()))
+		}
+	}
+
+	id, _ := primitive.ObjectIDFromHex(string(postId))
+	if _, err := s.posts.DeleteOne(context.TODO(), bson.D{{"_id", id}}); err != nil {
+		return err
+	}
+	if _, err := s.posts.DeleteMany(context.TODO(), bson.D{{"repostof", postId}}); err != nil {
+		return err
+	}
+	if _, err := s.revisions.DeleteMany(context.TODO(), bson.D{{"postid", postId}}); err != nil {
+		return err
+	}
+	if _, err := s.likes.DeleteMany(context.TODO(), bson.D{{"postid", bson.D{{"$in", postIds}}}}); err != nil {
+		return err
+	}
+	if _, err := s.notifications.DeleteMany(context.TODO(), bson.D{{"postid", bson.D{{"$in", postIds}}}}); err != nil {
+		return err
+	}
+
+	// drop the post and its reposts from every materialized feed they were
+	// fanned out to
+	filter := bson.D{{"$or", bson.A{
+		bson.D{{"posts.id", postId}},
+		bson.D{{"posts.repostof", postId}},
+	}}}
+	update := bson.D{{"$pull", bson.D{{"posts", bson.D{{"$or", bson.A{
+		bson.D{{"id", postId}},
+		bson.D{{"repostof", postId}},
+	}}}}}}}
+	_, err = s.feed.UpdateMany(context.TODO(), filter, update)
+	return err
+}
+
+func (s *MongoStorage) DeleteRepost(postId models.PostID, userId models.UserID) (models.PostID, error) {
+	if userId == "" {
+		return *new(models.PostID), models.ErrUnauthorized
+	}
+	post, err := s.GetPost(postId)
+	if err != nil {
+		return *new(models.PostID), err
+	}
+	if post.RepostOf != "" {
+		postId = post.RepostOf
+	}
+
+	var repost models.HexId
+	filter := bson.D{{"authorid", userId}, {"repostof", postId}}
+	err = s.posts.FindOne(context.TODO(), filter).Decode(&repost)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return *new(models.PostID), models.ErrNotFound
+	} else if err != nil {
+		return *new(models.PostID), err
+	}
+	repostId := models.PostID(repost.ID.Hex())
+	if err := s.DeletePost(repostId, userId); err != nil {
+		return repostId, err
+	}
+	return repostId, s.removeNotifications(models.NotificationRepost, userId, "", postId)
+}
+
+func (s *MongoStorage) LikePost(postId models.PostID, userId models.UserID) error {
+	if userId == "" {
+		return models.ErrUnauthorized
+	}
+	// likes of a repost count towards the reposted post
+	post, err := s.GetVisiblePost(userId, postId)
+	if err != nil {
+		return err
+	}
+	postId = post.Id
+
+	_, err = s.likes.InsertOne(context.TODO(), models.Like{
+		PostId: postId,
+		UserId: userId,
+	})
+	if err != nil && strings.Contains(err.Error(), "duplicate") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.addLikes(postId, 1)
+}
+
+func (s *MongoStorage) UnlikePost(postId models.PostID, userId models.UserID) error {
+	if userId == "" {
+		return models.ErrUnauthorized
+	}
+	postId, err := s.resolveRepost(postId)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.likes.DeleteOne(context.TODO(), models.Like{
+		PostId: postId,
+		UserId: userId,
+	})
+	if err != nil || result.DeletedCount == 0 {
+		return err
+	}
+	if err := s.removeNotifications(models.NotificationLike, userId, "", postId); err != nil {
+		return err
+	}
+	return s.addLikes(postId, -1)
+}
+
+// resolveRepost returns the id of the reposted post for a repost record, as
+// actions on a repost apply to the original post
+func (s *MongoStorage) resolveRepost(postId models.PostID) (models.PostID, error) {
+	post, err := s.GetPost(postId)
+	if err != nil {
+		return postId, err
+	}
+	if post.RepostOf == "" {
+		return postId, nil
+	}
+	if _, err := s.GetPost(post.RepostOf); err != nil {
+		return postId, err
+	}
+	return post.RepostOf, nil
+}
+
+func (s *MongoStorage) addLikes(postId models.PostID, delta int) error {
+	id, _ := primitive.ObjectIDFromHex(string(postId))
+	filter := bson.D{{"_id", id}}
+	update := bson.D{{"$inc", bson.D{{"likescount", delta}}}}
+	_, err := s.posts.UpdateOne(context.TODO(), filter, update)
+	return err
+}
+
+func (s *MongoStorage) DecoratePosts(viewerId models.UserID, posts []models.Post) ([]models.Post, error) {
+	posts, err := s.resolveReposts(posts)
+	if err != nil {
+		return nil, err
+	}
+	// reposts are resolved first, so that posts are hidden by their authors
+	// rather than by the users who reposted them
+	if posts, err = s.dropHiddenPosts(viewerId, posts); err != nil {
+		return nil, err
+	}
+	if err := s.embedQuotedPosts(viewerId, posts); err != nil {
+		return nil, err
+	}
+	if viewerId == "" || len(posts) == 0 {
+		return posts, nil
+	}
+
+	postIds := make([]models.PostID, 0, len(posts))
+	for _, post := range posts {
+		postIds = append(postIds, post.Id)
+	}
+	filter := bson.D{{"userid", viewerId}, {"postid", bson.M{"$in": postIds}}}
+	cur, err := s.likes.Find(context.TODO(), filter, options.Find())
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.TODO())
+
+	liked := make(map[models.PostID]bool)
+	for cur.Next(context.TODO()) {
+		var elem models.Like
+		if err := cur.Decode(&elem); err != nil {
+			return nil, err
+		}
+		liked[elem.PostId] = true
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range posts {
+		posts[i].LikedByMe = liked[posts[i].Id]
+	}
+	return posts, nil
+}
+
+// resolveReposts shows reposted posts in place of repost records, dropping
+// reposts of posts deleted since
+func (s *MongoStorage) resolveReposts(posts []models.Post) ([]models.Post, error) {
+	originalIds := make([]models.PostID, 0)
+	for _, post := range posts {
+		if post.RepostOf != "" {
+			originalIds = append(originalIds, post.RepostOf)
+		}
+	}
+	if len(originalIds) == 0 {
+		return posts, nil
+	}
+
+	originals, err := s.findPostsById(originalIds)
+	if err != nil {
+		return nil, err
+	}
+	resolved := make([]models.Post, 0, len(posts))
+	for _, post := range posts {
+		if post.RepostOf == "" {
+			resolved = append(resolved, post)
+		} else if original, found := originals[post.RepostOf]; found {
+			original.RepostedBy = post.AuthorId
+			resolved = append(resolved, original)
+		}
+	}
+	return resolved, nil
+}
+
+// dropHiddenPosts drops the posts the viewer may not see
+func (s *MongoStorage) dropHiddenPosts(viewerId models.UserID, posts []models.Post) ([]models.Post, error) {
+	authorIds := make([]models.UserID, 0, len(posts))
+	for _, post := range posts {
+		authorIds = append(authorIds, post.AuthorId)
+	}
+	hidden, err := s.getHiddenAuthors(viewerId, authorIds)
+	if err != nil || len(hidden) == 0 {
+		return posts, err
+	}
+
+	visible := make([]models.Post, 0, len(posts))
+	for _, post := range posts {
+		if !hidden[post.AuthorId] {
+			visible = append(visible, post)
+		}
+	}
+	return visible, nil
+}
+
+// embedQuotedPosts embeds the quoted posts the viewer may see
+func (s *MongoStorage) embedQuotedPosts(viewerId models.UserID, posts []models.Post) error {
+	quotedIds := make([]models.PostID, 0)
+	for _, post := range posts {
+		if post.QuotedPostId != "" {
+			quotedIds = append(quotedIds, post.QuotedPostId)
+		}
+	}
+	quoted := make(map[models.PostID]models.Post)
+	hidden := make(map[models.UserID]bool)
+	if len(quotedIds) > 0 {
+		var err error
+		if quoted, err = s.findPostsById(quotedIds); err != nil {
+			return err
+		}
+		authorIds := make([]models.UserID, 0, len(quoted))
+		for _, quotedPost := range quoted {
+			authorIds = append(authorIds, quotedPost.AuthorId)
+		}
+		if hidden, err = s.getHiddenAuthors(viewerId, authorIds); err != nil {
+			return err
+		}
+	}
+
+	for i, post := range posts {
+		posts[i].QuotedPost = nil
+		if quotedPost, found := quoted[post.QuotedPostId]; found && !hidden[quotedPost.AuthorId] {
+			posts[i].QuotedPost = models.NewQuotedPost(quotedPost)
+		}
+	}
+	return nil
+}
+
+// refreshPosts replaces copies of posts kept in feeds with their current
+// versions, dropping the posts deleted since.
+func (s *MongoStorage) refreshPosts(posts []models.Post) ([]models.Post, error) {
+	postIds := make([]models.PostID, 0, len(posts))
+	for _, post := range posts {
+		postIds = append(postIds, post.Id)
+	}
+	postsById, err := s.findPostsById(postIds)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed := make([]models.Post, 0, len(posts))
+	for _, post := range posts {
+		if current, found := postsById[post.Id]; found {
+			refreshed = append(refreshed, current)
+		}
+	}
+	return refreshed, nil
+}
+
+func (s *MongoStorage) findPostsById(postIds []models.PostID) (map[models.PostID]models.Post, error) {
+	ids := make([]primitive.ObjectID, 0, len(postIds))
+	for _, postId := range postIds {
+		if id, err := primitive.ObjectIDFromHex(string(postId)); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	cur, err := s.posts.Find(context.TODO(), bson.D{{"_id", bson.M{"$in": ids}}}, options.Find())
+	if err != nil {
+		return nil, err
+	}
+	posts, err := decodePosts(cur)
+	if err != nil {
+		return nil, err
+	}
+
+	postsById := make(map[models.PostID]models.Post)
+	for _, post := range posts {
+		postsById[post.Id] = post
+	}
+	return postsById, nil
+}
+
+func decodePosts(cur *mongo.Cursor) ([]models.Post, error) {
+	defer cur.Close(context.TODO())
+
+	posts := make([]models.Post, 0)
+	for cur.Next(context.TODO()) {
+		var elem models.Post
+		if err := cur.Decode(&elem); err != nil {
+			return nil, err
+		}
+		var id models.HexId
+		if err := cur.Decode(&id); err != nil {
+			return nil, err
+		}
+		elem.Id = models.PostID(id.ID.Hex())
+		if elem.Version == 0 {
+			elem.Version = 1
+		}
+		posts = append(posts, elem)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	return posts, nil
+}
+
+// findUsersPosts reads posts of the given authors in the feed order, starting
+// right after the cursor if it is set.
+func (s *MongoStorage) findUsersPosts(usersId []models.UserID, after *models.Cursor, skip int, limit int) ([]models.Post, error) {
+	filter := bson.D{{"authorid", bson.M{"$in": usersId}}}
+	if after != nil {
+		lastId, err := primitive.ObjectIDFromHex(string(after.Id))
+		if err != nil {
+			return nil, models.ErrBadRequest
+		}
+		filter = append(filter, bson.E{"$or", bson.A{
+			bson.D{{"createdtime", bson.D{{"$lt", after.Time}}}},
+			bson.D{{"createdtime", after.Time}, {"_id", bson.D{{"$lt", lastId}}}},
+		}})
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{"createdtime", -1}, {"_id", -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+	cur, err := s.posts.Find(context.TODO(), filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	return decodePosts(cur)
+}
+
+// findPostsPage runs a query over posts ordered by id, newest first, and
+// fetches a single extra post to find out whether there is a next page.
+func (s *MongoStorage) findPostsPage(filter bson.D, page models.PageRequest) (models.PostsPage, error) {
+	if page.After != nil {
+		lastId, err := primitive.ObjectIDFromHex(string(page.After.Id))
+		if err != nil {
+			return models.PostsPage{}, models.ErrBadRequest
+		}
+		filter = append(filter, bson.E{"_id", bson.D{{"$lt", lastId}}})
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{"_id", -1}}).
+		SetSkip(int64(page.Offset())).
+		SetLimit(int64(page.Size + 1))
+	cur, err := s.posts.Find(context.TODO(), filter, findOptions)
+	if err != nil {
+		return models.PostsPage{}, err
+	}
+	posts, err := decodePosts(cur)
+	if err != nil {
+		return models.PostsPage{}, err
+	}
+	if page.Page > 1 && len(posts) == 0 {
+		return models.PostsPage{}, models.ErrBadRequest
+	}
+
+	postsPage := models.PostsPage{Posts: posts}
+	if len(posts) > page.Size {
+		postsPage.Posts = posts[:page.Size]
+		last := posts[page.Size-1]
+		postsPage.Next = &models.Cursor{Id: last.Id, Time: last.CreatedTime}
+	}
+	return postsPage, nil
+}
+
+func (s *MongoStorage) GetUserPosts(userId models.UserID, page models.PageRequest) (models.PostsPage, error) {
+	return s.findPostsPage(bson.D{{"authorid", userId}}, page)
+}
+
+func (s *MongoStorage) GetReplies(postId models.PostID, page models.PageRequest) (models.PostsPage, error) {
+	if _, err := s.GetPost(postId); err != nil {
+		return models.PostsPage{}, err
+	}
+	return s.findPostsPage(bson.D{{"inreplyto", postId}}, page)
+}
+
+func (s *MongoStorage) GetTagPosts(tag string, page models.PageRequest) (models.PostsPage, error) {
+	return s.findPostsPage(bson.D{{"tags", tag}}, page)
+}
+
+func (s *MongoStorage) GetMentions(userId models.UserID, page models.PageRequest) (models.PostsPage, error) {
+	if userId == "" {
+		return models.PostsPage{}, models.ErrUnauthorized
+	}
+	return s.findPostsPage(bson.D{{"mentions", userId}}, page)
+}
+
+// SearchPosts finds posts by the text index. Posts are ordered by relevance
+// or by recency, the relevance cursor also keeps the score of the last post.
+func (s *MongoStorage) SearchPosts(query models.SearchQuery, page models.PageRequest) (models.PostsPage, error) {
+	if strings.TrimSpace(query.Text) == "" {
+		return *new(models.PostsPage), models.ErrBadRequest
+	}
+
+	filter := bson.D{{"$text", bson.D{{"$search", query.Text}}}}
+	if query.AuthorId != "" {
+		filter = append(filter, bson.E{"authorid", query.AuthorId})
+	}
+	createdTime := bson.D{}
+	if !query.Since.IsZero() {
+		createdTime = append(createdTime, bson.E{"$gte", query.Since})
+	}
+	if !query.Until.IsZero() {
+		createdTime = append(createdTime, bson.E{"$lt", query.Until})
+	}
+	if len(createdTime) > 0 {
+		filter = append(filter, bson.E{"createdtime", createdTime})
+	}
+
+	if query.Sort == models.SearchSortRecent {
+		return s.findPostsPage(filter, page)
+	}
+
+	pipeline := mongo.Pipeline{
+		{{"$match", filter}},
+		{{"$addFields", bson.D{{"score", bson.D{{"$meta", "textScore"}}}}}},
+	}
+	if page.After != nil {
+		lastId, err := primitive.ObjectIDFromHex(string(page.After.Id))
+		if err != nil {
+			return *new(models.PostsPage), models.ErrBadRequest
+		}
+		pipeline = append(pipeline, bson.D{{"$match", bson.D{{"$or", bson.A{
+			bson.D{{"score", bson.D{{"$lt", page.After.Score}}}},
+			bson.D{{"score", page.After.Score}, {"_id", bson.D{{"$lt", lastId}}}},
+		}}}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{"$sort", bson.D{{"score", -1}, {"_id", -1}}}},
+		bson.D{{"$skip", page.Offset()}},
+		bson.D{{"$limit", page.Size + 1}},
+	)
+
+	cur, err := s.posts.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return *new(models.PostsPage), err
+	}
+	defer cur.Close(context.TODO())
+
+	posts := make([]models.Post, 0)
+	scores := make([]float64, 0)
+	for cur.Next(context.TODO()) {
+		var elem models.Post
+		if err := cur.Decode(&elem); err != nil {
+			return *new(models.PostsPage), err
+		}
+		var meta struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Score float64            `bson:"score"`
+		}
+		if err := cur.Decode(&meta); err != nil {
+			return *new(models.PostsPage), err
+		}
+		elem.Id = models.PostID(meta.ID.Hex())
+		posts = append(posts, elem)
+		scores = append(scores, meta.Score)
+	}
+	if err := cur.Err(); err != nil {
+		return *new(models.PostsPage), err
+	}
+	if page.Page > 1 && len(posts) == 0 {
+		return *new(models.PostsPage), models.ErrBadRequest
+	}
+
+	postsPage := models.PostsPage{Posts: posts}
+	if len(posts) > page.Size {
+		postsPage.Posts = posts[:page.Size]
+		last := posts[page.Size-1]
+		postsPage.Next = &models.Cursor{Id: last.Id, Time: last.CreatedTime, Score: scores[page.Size-1]}
+	}
+	return postsPage, nil
+}
+
+func (s *MongoStorage) GetThread(postId models.PostID, page models.PageRequest) (models.Thread, error) {
+	return getThread(s, postId, page)
+}
+
+// isNewer defines the order of posts on a page: newest first, ties are broken
+// by id so that a cursor always points at a single position.
+func isNewer(post models.Post, other models.Post) bool {
+	if !post.CreatedTime.Equal(other.CreatedTime) {
+		return post.CreatedTime.After(other.CreatedTime)
+	}
+	return post.Id > other.Id
+}
+
+// pageStart finds where the requested page begins in posts sorted by isNewer.
+func pageStart(posts []models.Post, page models.PageRequest) int {
+	if page.After == nil {
+		return page.Offset()
+	}
+	last := models.Post{Id: page.After.Id, CreatedTime: page.After.Time}
+	return sort.Search(len(posts), func(i int) bool {
+		return isNewer(last, posts[i])
+	})
+}
+
+func getPostsPage(posts []models.Post, page models.PageRequest) (models.PostsPage, error) {
+	from := pageStart(posts, page)
+	if from < 0 || from > len(posts) {
+		return models.PostsPage{}, models.ErrBadRequest
+	}
+	to := from + page.Size
+	if to > len(posts) {
+		to = len(posts)
+	}
+
+	postsPage := models.PostsPage{}
+	postsPage.Posts = posts[from:to]
+	if to < len(posts) {
+		last := posts[to-1]
+		postsPage.Next = &models.Cursor{Id: last.Id, Time: last.CreatedTime}
+	}
+	return postsPage, nil
+}
+
+// AddNotification stores a notification unless the user has already been
+// notified about the same event, e.g. edits of a post do not notify the
+// mentioned users again. Notifications about follows and likes are removed
+// once these are undone, so that doing them again notifies anew.
+func (s *MongoStorage) AddNotification(notification models.Notification) error {
+	if notification.UserId == "" || notification.UserId == notification.ActorId {
+		return nil
+	}
+
+	_, err := s.notifications.InsertOne(context.TODO(), notification)
+	if err != nil && strings.Contains(err.Error(), "duplicate") {
+		return nil
+	}
+	return err
+}
+
+// removeNotifications removes notifications about an undone action of the
+// actor. Follows are found by the followed user and other actions by the
+// post, the other one is left empty.
+func (s *MongoStorage) removeNotifications(notificationType string, actorId models.UserID, userId models.UserID, postId models.PostID) error {
+	filter := bson.D{{"type", notificationType}, {"actorid", actorId}}
+	if userId != "" {
+		filter = append(filter, bson.E{"userid", userId})
+	}
+	if postId != "" {
+		filter = append(filter, bson.E{"postid", postId})
+	}
+	_, err := s.notifications.DeleteMany(context.TODO(), filter)
+	return err
+}
+
+// CreateNotifications is run by the worker. It finds out who should be
+// notified about the event and stores the notifications. The user is set
+// only for new followers, for other events the users are found by the post.
+func (s *MongoStorage) CreateNotifications(notificationType string, actorId string, userId string, postId string) error {
+	usersId := make([]models.UserID, 0)
+	if notificationType == models.NotificationFollow || notificationType == models.NotificationFollowRequest {
+		usersId = append(usersId, models.UserID(userId))
+	} else {
+		post, err := s.GetPost(models.PostID(postId))
+		if errors.Is(err, models.ErrNotFound) {
+			// the post has been deleted before the task was run
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch notificationType {
+		case models.NotificationLike, models.NotificationRepost:
+			usersId = append(usersId, post.AuthorId)
+		case models.NotificationMention:
+			usersId = append(usersId, post.Mentions...)
+		case models.NotificationReply:
+			parent, err := s.GetPost(post.InReplyTo)
+			if errors.Is(err, models.ErrNotFound) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			usersId = append(usersId, parent.AuthorId)
+		default:
+			return models.ErrBadRequest
+		}
+	}
+
+	now := time.Now()
+	for _, user := range usersId {
+		err := s.AddNotification(models.Notification{
+			UserId:      user,
+			Type:        notificationType,
+			ActorId:     models.UserID(actorId),
+			PostId:      models.PostID(postId),
+			CreatedAt:   now.Format("2006-01-02T15:04:05.999Z"),
+			CreatedTime: now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetNotifications returns notifications of the user newest first along
+// with the number of unread ones.
+func (s *MongoStorage) GetNotifications(userId models.UserID, page models.PageRequest) (models.NotificationsPage, error) {
+	if userId == "" {
+		return *new(models.NotificationsPage), models.ErrUnauthorized
+	}
+
+	filter := bson.D{{"userid", userId}}
+	if page.After != nil {
+		lastId, err := primitive.ObjectIDFromHex(string(page.After.Id))
+		if err != nil {
+			return *new(models.NotificationsPage), models.ErrBadRequest
+		}
+		filter = append(filter, bson.E{"_id", bson.D{{"$lt", lastId}}})
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{"_id", -1}}).
+		SetSkip(int64(page.Offset())).
+		SetLimit(int64(page.Size + 1))
+	cur, err := s.notifications.Find(context.TODO(), filter, findOptions)
+	if err != nil {
+		return *new(models.NotificationsPage), err
+	}
+	defer cur.Close(context.TODO())
+
+	notifications := make([]models.Notification, 0)
+	for cur.Next(context.TODO()) {
+		var elem models.Notification
+		if err := cur.Decode(&elem); err != nil {
+			return *new(models.NotificationsPage), err
+		}
+		var id models.HexId
+		if err := cur.Decode(&id); err != nil {
+			return *new(models.NotificationsPage), err
+		}
+		elem.Id = id.ID.Hex()
+		notifications = append(notifications, elem)
+	}
+	if err := cur.Err(); err != nil {
+		return *new(models.NotificationsPage), err
+	}
+	if page.Page > 1 && len(notifications) == 0 {
+		return *new(models.NotificationsPage), models.ErrBadRequest
+	}
+
+	notificationsPage := models.NotificationsPage{Notifications: notifications}
+	if len(notifications) > page.Size {
+		notificationsPage.Notifications = notifications[:page.Size]
+		last := notifications[page.Size-1]
+		notificationsPage.Next = &models.Cursor{Id: models.PostID(last.Id), Time: last.CreatedTime}
+	}
+
+	notificationsPage.UnreadCount, err = s.CountUnreadNotifications(userId)
+	return notificationsPage, err
+}
+
+func (s *MongoStorage) CountUnreadNotifications(userId models.UserID) (int64, error) {
+	return s.notifications.CountDocuments(context.TODO(), bson.D{{"userid", userId}, {"read", false}})
+}
+
+// MarkNotificationsRead marks the given notifications of the user as read,
+// or all of them if no ids are given.
+func (s *MongoStorage) MarkNotificationsRead(userId models.UserID, notificationsId []string) error {
+	if userId == "" {
+		return models.ErrUnauthorized
+	}
+
+	filter := bson.D{{"userid", userId}, {"read", false}}
+	if len(notificationsId) > 0 {
+		ids := make([]primitive.ObjectID, 0, len(notificationsId))
+		for _, notificationId := range notificationsId {
+			id, err := primitive.ObjectIDFromHex(notificationId)
+			if err != nil {
+				return models.ErrBadRequest
+			}
+			ids = append(ids, id)
+		}
+		filter = append(filter, bson.E{"_id", bson.M{"$in": ids}})
+	}
+
+	update := bson.D{{"$set", bson.D{{"read", true}}}}
+	_, err := s.notifications.UpdateMany(context.TODO(), filter, update)
+	return err
+}
+
+func (s *MongoStorage) GetUser(userId models.UserID) (models.User, error) {
+	var user models.User
+	err := s.users.FindOne(context.TODO(), bson.D{{"_id", userId}}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return *new(models.User), models.ErrUserNotFound
+	}
+	return user, err
+}
+
+// handleIndex is named in duplicate key errors of taken handles
+const handleIndex = "handle_1"
+
+// UpdateUser changes the given fields of the profile, creating the profile
+// if the user has none yet
+func (s *MongoStorage) UpdateUser(userId models.UserID, userUpdate models.UserUpdate) (models.User, error) {
+	if userId == "" {
+		return *new(models.User), models.ErrUnauthorized
+	}
+
+	fields := bson.D{}
+	if userUpdate.Handle != nil {
+		fields = append(fields, bson.E{"handle", *userUpdate.Handle})
+	}
+	if userUpdate.DisplayName != nil {
+		fields = append(fields, bson.E{"displayname", *userUpdate.DisplayName})
+	}
+	if userUpdate.Bio != nil {
+		fields = append(fields, bson.E{"bio", *userUpdate.Bio})
+	}
+	if userUpdate.AvatarUrl != nil {
+		fields = append(fields, bson.E{"avatarurl", *userUpdate.AvatarUrl})
+	}
+	if userUpdate.Private != nil {
+		fields = append(fields, bson.E{"private", *userUpdate.Private})
+	}
+	update := bson.D{{"$setOnInsert", bson.D{{"_id", userId}}}}
+	if len(fields) > 0 {
+		update = append(update, bson.E{"$set", fields})
+	}
+
+	var user models.User
+	updateOptions := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := s.users.FindOneAndUpdate(context.TODO(), bson.D{{"_id", userId}}, update, updateOptions).Decode(&user)
+	if mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), handleIndex) {
+		return *new(models.User), models.ErrHandleTaken
+	}
+	return user, err
+}
+
+// UserExists checks whether the user has a profile or has posted anything
+func (s *MongoStorage) UserExists(userId models.UserID) (bool, error) {
+	countOptions := options.Count().SetLimit(1)
+	count, err := s.users.CountDocuments(context.TODO(), bson.D{{"_id", userId}}, countOptions)
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+	count, err = s.posts.CountDocuments(context.TODO(), bson.D{{"authorid", userId}}, countOptions)
+	return count > 0, err
+}
+
+func (s *MongoStorage) GetUserByHandle(handle string) (models.User, error) {
+	var user models.User
+	err := s.users.FindOne(context.TODO(), bson.D{{"handle", handle}}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return *new(models.User), models.ErrUserNotFound
+	}
+	return user, err
+}
+
+// GetUserSummaries returns profile summaries of the users in the same
+// order. Users without a profile get a summary with the id only.
+func (s *MongoStorage) GetUserSummaries(usersId []models.UserID) ([]models.UserSummary, error) {
+	summaries := make([]models.UserSummary, 0, len(usersId))
+	if len(usersId) == 0 {
+		return summaries, nil
+	}
+
+	cur, err := s.users.Find(context.TODO(), bson.D{{"_id", bson.M{"$in": usersId}}}, options.Find())
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.TODO())
+
+	usersById := make(map[models.UserID]models.User)
+	for cur.Next(context.TODO()) {
+		var elem models.User
+		if err := cur.Decode(&elem); err != nil {
+			return nil, err
+		}
+		usersById[elem.Id] = elem
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, userId := range usersId {
+		user, found := usersById[userId]
+		if !found {
+			user.Id = userId
+		}
+		summaries = append(summaries, *models.NewUserSummary(user))
+	}
+	return summaries, nil
+}
+
+func (s *MongoStorage) AddSubscription(subscription models.Subscription) error {
+	if subscription.From == "" {
+		return models.ErrUnauthorized
+	}
+	if subscription.To == "" || subscription.From == subscription.To {
+		return models.ErrBadRequest
+	}
+	blocked, err := s.isBlockedEitherWay(subscription.From, subscription.To)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return models.ErrBlocked
+	}
+
+	s.feed.InsertOne(context.TODO(), models.Feed{
+		User:  subscription.From,
+		Posts: make([]models.Post, 0),
+	})
+	_, err = s.subscriptions.InsertOne(context.TODO(), subscription)
+	if err != nil && strings.Contains(err.Error(), "duplicate") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.addFollowers(subscription.To, 1)
+}
+
+func (s *MongoStorage) RemoveSubscription(subscription models.Subscription) error {
+	if subscription.From == "" {
+		return models.ErrUnauthorized
+	}
+	if subscription.To == "" || subscription.From == subscription.To {
+		return models.ErrBadRequest
+	}
+
+	// unsubscribing also withdraws a request that is not approved yet
+	_, err := s.followRequests.DeleteOne(context.TODO(), bson.D{{"from", subscription.From}, {"to", subscription.To}})
+	if err != nil {
+		return err
+	}
+	if err := s.removeNotifications(models.NotificationFollowRequest, subscription.From, subscription.To, ""); err != nil {
+		return err
+	}
+
+	result, err := s.subscriptions.DeleteOne(context.TODO(), subscription)
+	if err != nil || result.DeletedCount == 0 {
+		return err
+	}
+	if err := s.removeNotifications(models.NotificationFollow, subscription.From, subscription.To, ""); err != nil {
+		return err
+	}
+	return s.addFollowers(subscription.To, -1)
+}
+
+// IsSubscribed checks whether the user From is an approved subscriber of the
+// user To
+func (s *MongoStorage) IsSubscribed(subscription models.Subscription) (bool, error) {
+	if subscription.From == "" || subscription.To == "" {
+		return false, nil
+	}
+	count, err := s.subscriptions.CountDocuments(context.TODO(), subscription)
+	return count > 0, err
+}
+
+// AddFollowRequest asks the owner of a private account to approve the
+// subscription. It returns false if the user is already subscribed, so there
+// is nothing to approve.
+func (s *MongoStorage) AddFollowRequest(subscription models.Subscription) (bool, error) {
+	if subscription.From == "" {
+		return false, models.ErrUnauthorized
+	}
+	if subscription.To == "" || subscription.From == subscription.To {
+		return false, models.ErrBadRequest
+	}
+	blocked, err := s.isBlockedEitherWay(subscription.From, subscription.To)
+	if err != nil {
+		return false, err
+	}
+	if blocked {
+		return false, models.ErrBlocked
+	}
+	subscribed, err := s.IsSubscribed(subscription)
+	if err != nil || subscribed {
+		return false, err
+	}
+
+	_, err = s.followRequests.InsertOne(context.TODO(), models.FollowRequest{
+		From:      subscription.From,
+		To:        subscription.To,
+		CreatedAt: time.Now().Format("2006-01-02T15:04:05.999Z"),
+	})
+	if err != nil && strings.Contains(err.Error(), "duplicate") {
+		return true, nil
+	}
+	return err == nil, err
+}
+
+// GetFollowRequests returns pending requests to follow the user, oldest first
+func (s *MongoStorage) GetFollowRequests(userId models.UserID) (models.FollowRequestsList, error) {
+	if userId == "" {
+		return *new(models.FollowRequestsList), models.ErrUnauthorized
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{"_id", 1}})
+	cur, err := s.followRequests.Find(context.TODO(), bson.D{{"to", userId}}, findOptions)
+	if err != nil {
+		return *new(models.FollowRequestsList), err
+	}
+	defer cur.Close(context.TODO())
+
+	requests := models.FollowRequestsList{
+		Requests: make([]models.FollowRequest, 0),
+	}
+	for cur.Next(context.TODO()) {
+		var elem models.FollowRequest
+		if err := cur.Decode(&elem); err != nil {
+			return *new(models.FollowRequestsList), err
+		}
+		requests.Requests = append(requests.Requests, elem)
+	}
+	if err := cur.Err(); err != nil {
+		return *new(models.FollowRequestsList), err
+	}
+
+	return requests, nil
+}
+
+// ApproveFollowRequest turns the request of the follower into a subscription
+func (s *MongoStorage) ApproveFollowRequest(userId models.UserID, followerId models.UserID) error {
+	if err := s.removeFollowRequest(userId, followerId); err != nil {
+		return err
+	}
+	return s.AddSubscription(models.Subscription{
+		From: followerId,
+		To:   userId,
+	})
+}
+
+func (s *MongoStorage) DenyFollowRequest(userId models.UserID, followerId models.UserID) error {
+	return s.removeFollowRequest(userId, followerId)
+}
+
+func (s *MongoStorage) removeFollowRequest(userId models.UserID, followerId models.UserID) error {
+	if userId == "" {
+		return models.ErrUnauthorized
+	}
+
+	result, err := s.followRequests.DeleteOne(context.TODO(), bson.D{{"from", followerId}, {"to", userId}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return models.ErrFollowRequestNotFound
+	}
+	return s.removeNotifications(models.NotificationFollowRequest, followerId, userId, "")
+}
+
+func (s *MongoStorage) BlockUser(userId models.UserID, blockedId models.UserID) error {
+	if userId == "" {
+		return models.ErrUnauthorized
+	}
+	if blockedId == "" || userId == blockedId {
+		return models.ErrBadRequest
+	}
+
+	_, err := s.blocks.InsertOne(context.TODO(), models.Block{
+		From: userId,
+		To:   blockedId,
+	})
+	if err != nil && !strings.Contains(err.Error(), "duplicate") {
+		return err
+	}
+
+	// a retried block finishes removing the subscriptions
+	for _, subscription := range []models.Subscription{
+		{From: userId, To: blockedId},
+		{From: blockedId, To: userId},
+	} {
+		if err := s.RemoveSubscription(subscription); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MongoStorage) UnblockUser(userId models.UserID, blockedId models.UserID) error {
+	if userId == "" {
+		return models.ErrUnauthorized
+	}
+
+	_, err := s.blocks.DeleteOne(context.TODO(), models.Block{
+		From: userId,
+		To:   blockedId,
+	})
+	return err
+}
+
+func (s *MongoStorage) IsBlocked(userId models.UserID, otherId models.UserID) (bool, error) {
+	if userId == "" || otherId == "" {
+		return false, nil
+	}
+	count, err := s.blocks.CountDocuments(context.TODO(), models.Block{
+		From: userId,
+		To:   otherId,
+	})
+	return count > 0, err
+}
+
+func (s *MongoStorage) CanSeePosts(viewerId models.UserID, authorId models.UserID) (bool, error) {
+	hidden, err := s.getHiddenAuthors(viewerId, []models.UserID{authorId})
+	return !hidden[authorId], err
+}
+
+// getHiddenAuthors returns the authors whose posts the viewer may not see.
+// Users blocked by the author may not, and posts of private accounts are
+// shown to the owner and approved subscribers only.
+func (s *MongoStorage) getHiddenAuthors(viewerId models.UserID, authorIds []models.UserID) (map[models.UserID]bool, error) {
+	hidden := make(map[models.UserID]bool)
+	others := make([]models.UserID, 0, len(authorIds))
+	for _, authorId := range authorIds {
+		if authorId != viewerId {
+			others = append(others, authorId)
+		}
+	}
+	if len(others) == 0 {
+		return hidden, nil
+	}
+
+	if viewerId != "" {
+		filter := bson.D{{"from", bson.D{{"$in", others}}}, {"to", viewerId}}
+		if err := s.collectUsers(s.blocks, filter, "from", hidden); err != nil {
+			return nil, err
+		}
+	}
+
+	private := make(map[models.UserID]bool)
+	filter := bson.D{{"_id", bson.D{{"$in", others}}}, {"private", true}}
+	if err := s.collectUsers(s.users, filter, "_id", private); err != nil {
+		return nil, err
+	}
+	if len(private) == 0 {
+		return hidden, nil
+	}
+	subscribed := make(map[models.UserID]bool)
+	if viewerId != "" {
+		privateIds := make([]models.UserID, 0, len(private))
+		for authorId := range private {
+			privateIds = append(privateIds, authorId)
+		}
+		filter := bson.D{{"from", viewerId}, {"to", bson.D{{"$in", privateIds}}}}
+		if err := s.collectUsers(s.subscriptions, filter, "to", subscribed); err != nil {
+			return nil, err
+		}
+	}
+	for authorId := range private {
+		if !subscribed[authorId] {
+			hidden[authorId] = true
+		}
+	}
+	return hidden, nil
+}
 
-	insertResult, err := s.posts.InsertOne(context.TODO(), post)
+// collectUsers adds the users found in the field of the matching documents
+// to the set
+func (s *MongoStorage) collectUsers(collection *mongo.Collection, filter bson.D, field string, users map[models.UserID]bool) error {
+	findOptions := options.Find().SetProjection(bson.D{{field, 1}})
+	cur, err := collection.Find(context.TODO(), filter, findOptions)
 	if err != nil {
-		return *new(models.PostID), err
+		return err
 	}
+	defer cur.Close(context.TODO())
 
-	return models.PostID(insertResult.InsertedID.(primitive.ObjectID).Hex()), nil
+	for cur.Next(context.TODO()) {
+		userId, ok := cur.Current.Lookup(field).StringValueOK()
+		if !ok {
+			continue
+		}
+		users[models.UserID(userId)] = true
+	}
+	return cur.Err()
 }
 
-func (s *MongoStorage) GetPost(postId models.PostID) (models.Post, error) {
-	id, err := primitive.ObjectIDFromHex(string(postId))
+func (s *MongoStorage) isBlockedEitherWay(userId models.UserID, otherId models.UserID) (bool, error) {
+	filter := bson.D{{"$or", bson.A{
+		bson.D{{"from", userId}, {"to", otherId}},
+		bson.D{{"from", otherId}, {"to", userId}},
+	}}}
+	count, err := s.blocks.CountDocuments(context.TODO(), filter)
+	return count > 0, err
+}
+
+// getBlockedUsers returns the users blocked by the user
+func (s *MongoStorage) getBlockedUsers(userId models.UserID) (map[models.UserID]bool, error) {
+	cur, err := s.blocks.Find(context.TODO(), bson.D{{"from", userId}}, options.Find())
 	if err != nil {
-		return *new(models.Post), models.ErrNotFound
+		return nil, err
 	}
+	defer cur.Close(context.TODO())
 
-	var result models.Post
-	err = s.posts.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&result)
-	if errors.Is(err, mongo.ErrNoDocuments) {
-		return *new(models.Post), models.ErrNotFound
+	blocked := make(map[models.UserID]bool)
+	for cur.Next(context.TODO()) {
+		var elem models.Block
+		if err := cur.Decode(&elem); err != nil {
+			return nil, err
+		}
+		blocked[elem.To] = true
 	}
-	result.Id = postId
-	return result, err
+	return blocked, cur.Err()
 }
 
-func (s *MongoStorage) UpdatePost(postUpdate models.Post) (models.Post, error) {
-	if postUpdate.AuthorId == "" {
-		return *new(models.Post), models.ErrUnauthorized
-	}
-	post, err := s.GetPost(postUpdate.Id)
-	if err != nil {
-		return *new(models.Post), err
+// AddMute mutes a user or a keyword. Muting the same again only changes the
+// expiry time.
+func (s *MongoStorage) AddMute(mute models.Mute) (models.Mute, error) {
+	if mute.UserId == "" {
+		return *new(models.Mute), models.ErrUnauthorized
 	}
-	if post.AuthorId != postUpdate.AuthorId {
-		return *new(models.Post), models.ErrFobidden
+	if (mute.MutedUserId == "") == (mute.Keyword == "") || mute.MutedUserId == mute.UserId {
+		return *new(models.Mute), models.ErrBadRequest
 	}
 
-	id, _ := primitive.ObjectIDFromHex(string(postUpdate.Id))
-	filter := bson.D{{"_id", id}}
-	update := bson.D{{"$set", bson.D{{"text", postUpdate.Text}, {"lastmodifiedat", postUpdate.LastModifiedAt}}}}
+	filter := bson.D{{"userid", mute.UserId}}
+	if mute.MutedUserId != "" {
+		filter = append(filter, bson.E{"muteduserid", mute.MutedUserId}, bson.E{"keyword", bson.D{{"$exists", false}}})
+	} else {
+		filter = append(filter, bson.E{"muteduserid", bson.D{{"$exists", false}}}, bson.E{"keyword", mute.Keyword})
+	}
+	update := bson.D{{"$set", bson.D{
+		{"expiresat", mute.ExpiresAt},
+		{"expirestime", mute.ExpiresTime},
+	}}}
 
-	if _, err := s.posts.UpdateOne(context.TODO(), filter, update); err != nil {
-		return *new(models.Post), err
+	updateOptions := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	result := s.mutes.FindOneAndUpdate(context.TODO(), filter, update, updateOptions)
+	var id models.HexId
+	if err := result.Decode(&id); err != nil {
+		return *new(models.Mute), err
 	}
-	post.Text = postUpdate.Text
-	post.LastModifiedAt = postUpdate.LastModifiedAt
-	return post, nil
+	mute.Id = id.ID.Hex()
+	return mute, nil
 }
 
-func (s *MongoStorage) getAllUserPosts(userId models.UserID) ([]models.Post, error) {
-	findOptions := options.Find()
-	cur, err := s.posts.Find(context.TODO(), bson.D{{"authorid", userId}}, findOptions)
+// GetMutes returns mutes of the user that have not expired yet
+func (s *MongoStorage) GetMutes(userId models.UserID) (models.MutesList, error) {
+	if userId == "" {
+		return *new(models.MutesList), models.ErrUnauthorized
+	}
+
+	filter := bson.D{{"userid", userId}, {"$or", bson.A{
+		bson.D{{"expirestime", nil}},
+		bson.D{{"expirestime", bson.D{{"$gt", time.Now()}}}},
+	}}}
+	findOptions := options.Find().SetSort(bson.D{{"_id", 1}})
+	cur, err := s.mutes.Find(context.TODO(), filter, findOptions)
 	if err != nil {
-		return nil, err
+		return *new(models.MutesList), err
+	}
+	defer cur.Close(context.TODO())
+
+	mutes := models.MutesList{
+		Mutes: make([]models.Mute, 0),
 	}
-	posts := make([]models.Post, 0)
 	for cur.Next(context.TODO()) {
-		var elem models.Post
+		var elem models.Mute
 		if err := cur.Decode(&elem); err != nil {
-			return nil, err
+			return *new(models.MutesList), err
 		}
 		var id models.HexId
 		if err := cur.Decode(&id); err != nil {
-			return nil, err
+			return *new(models.MutesList), err
 		}
-		elem.Id = models.PostID(id.ID.Hex())
-		posts = append(posts, elem)
+		elem.Id = id.ID.Hex()
+		mutes.Mutes = append(mutes.Mutes, elem)
 	}
 	if err := cur.Err(); err != nil {
-		return nil, err
+		return *new(models.MutesList), err
 	}
-	cur.Close(context.TODO())
 
-	return posts, nil
+	return mutes, nil
 }
 
-func (s *MongoStorage) getAllUsersPosts(usersId []models.UserID) ([]models.Post, error) {
-	findOptions := options.Find()
-	cur, err := s.posts.Find(context.TODO(), bson.D{{"authorid", bson.M{"$in": usersId}}}, findOptions)
+func (s *MongoStorage) DeleteMute(userId models.UserID, muteId string) error {
+	if userId == "" {
+		return models.ErrUnauthorized
+	}
+	id, err := primitive.ObjectIDFromHex(muteId)
 	if err != nil {
-		return nil, err
+		return models.ErrMuteNotFound
 	}
-	posts := make([]models.Post, 0)
-	for cur.Next(context.TODO()) {
-		var elem models.Post
-		if err := cur.Decode(&elem); err != nil {
-			return nil, err
-		}
-		var id models.HexId
-		if err := cur.Decode(&id); err != nil {
-			return nil, err
-		}
-		elem.Id = models.PostID(id.ID.Hex())
-		posts = append(posts, elem)
+
+	result, err := s.mutes.DeleteOne(context.TODO(), bson.D{{"_id", id}, {"userid", userId}})
+	if err != nil {
+		return err
 	}
-	if err := cur.Err(); err != nil {
-		return nil, err
+	if result.DeletedCount == 0 {
+		return models.ErrMuteNotFound
 	}
-	cur.Close(context.TODO())
-
-	return posts, nil
+	return nil
 }
 
-func (s *MongoStorage) GetUserPosts(userId models.UserID, page int, size int) (models.PostsPage, error) {
-	allUserPosts, err := s.getAllUserPosts(userId)
+func (s *MongoStorage) getFeedFilter(userId models.UserID) (feedFilter, error) {
+	blocked, err := s.getBlockedUsers(userId)
 	if err != nil {
-		return models.PostsPage{}, err
+		return feedFilter{}, err
+	}
+	mutes, err := s.GetMutes(userId)
+	if err != nil {
+		return feedFilter{}, err
+	}
+
+	filter := feedFilter{hiddenUsers: blocked}
+	for _, mute := range mutes.Mutes {
+		if mute.MutedUserId != "" {
+			filter.hiddenUsers[mute.MutedUserId] = true
+		} else {
+			filter.phrases = append(filter.phrases, strings.Split(mute.Keyword, " "))
+		}
 	}
+	return filter, nil
+}
 
-	l, r := 0, len(allUserPosts)-1
-	for l < r {
-		allUserPosts[l], allUserPosts[r] = allUserPosts[r], allUserPosts[l]
-		l++
-		r--
+func (s *MongoStorage) addFollowers(userId models.UserID, delta int) error {
+	filter := bson.D{{"user", userId}}
+	update := bson.D{{"$inc", bson.D{{"count", delta}}}}
+	updateOptions := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var followers models.FollowersCount
+	err := s.followers.FindOneAndUpdate(context.TODO(), filter, update, updateOptions).Decode(&followers)
+	if err != nil || followers.Popular || !s.isPopular(followers.Count) {
+		return err
 	}
 
-	return getPostsPage(allUserPosts, page, size)
+	_, err = s.followers.UpdateOne(context.TODO(), filter, bson.D{{"$set", bson.D{{"popular", true}}}})
+	return err
 }
 
-func getPostsPage(posts []models.Post, page int, size int) (models.PostsPage, error) {
-	from := (page - 1) * size
-	if from < 0 || from > len(posts) {
-		return models.PostsPage{}, models.ErrBadRequest
+func (s *MongoStorage) isPopular(followersCount int) bool {
+	return s.feedConfig.FanoutThreshold > 0 && followersCount >= s.feedConfig.FanoutThreshold
+}
+
+// backfillFollowers counts followers of every author from subscriptions.
+// Counters are kept up to date by subscriptions since then, so it only runs
+// while there are none, i.e. on the first start with a database that
+// predates them.
+func (s *MongoStorage) backfillFollowers() error {
+	if count, err := s.followers.EstimatedDocumentCount(context.TODO()); err != nil || count > 0 {
+		return err
 	}
-	to := from + size
-	if to > len(posts) {
-		to = len(posts)
+
+	popular := bson.D{{"$literal", false}}
+	if s.feedConfig.FanoutThreshold > 0 {
+		popular = bson.D{{"$gte", bson.A{"$count", s.feedConfig.FanoutThreshold}}}
+	}
+	pipeline := mongo.Pipeline{
+		{{"$group", bson.D{{"_id", "$to"}, {"count", bson.D{{"$sum", 1}}}}}},
+		{{"$project", bson.D{{"_id", 0}, {"user", "$_id"}, {"count", 1}, {"popular", popular}}}},
+		// subscriptions made during the backfill have already been counted
+		// by the aggregation, so the computed counters win
+		{{"$merge", bson.D{
+			{"into", s.followers.Name()},
+			{"on", "user"},
+			{"whenMatched", bson.A{bson.D{{"$set", bson.D{
+				{"count", "$$new.count"},
+				{"popular", "$$new.popular"},
+			}}}}},
+			{"whenNotMatched", "insert"},
+		}}},
+	}
+	cur, err := s.subscriptions.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return err
 	}
+	return cur.Close(context.TODO())
+}
 
-	postsPage := models.PostsPage{}
-	postsPage.Posts = posts[from:to]
-	if to < len(posts) {
-		postsPage.NextPage = fmt.Sprint(page + 1)
+// getPopularUsers returns the authors whose posts are not pushed into feeds
+func (s *MongoStorage) getPopularUsers(usersId []models.UserID) ([]models.UserID, error) {
+	if s.feedConfig.FanoutThreshold <= 0 {
+		return nil, nil
 	}
-	return postsPage, nil
+	return s.findFollowersCounts(bson.D{
+		{"user", bson.M{"$in": usersId}},
+		{"count", bson.D{{"$gte", s.feedConfig.FanoutThreshold}}},
+	})
 }
 
-func (s *MongoStorage) AddSubscription(subscription models.Subscription) error {
-	if subscription.From == "" || subscription.To == "" || subscription.From == subscription.To {
-		return models.ErrBadRequest
+// getMergedUsers returns the authors whose posts are merged into feeds on
+// read: the popular ones and those that have been popular before, whose
+// posts made back then are missing from feeds
+func (s *MongoStorage) getMergedUsers(usersId []models.UserID) ([]models.UserID, error) {
+	filter := bson.D{{"user", bson.M{"$in": usersId}}, {"popular", true}}
+	if s.feedConfig.FanoutThreshold > 0 {
+		filter = bson.D{{"user", bson.M{"$in": usersId}}, {"$or", bson.A{
+			bson.D{{"popular", true}},
+			bson.D{{"count", bson.D{{"$gte", s.feedConfig.FanoutThreshold}}}},
+		}}}
 	}
+	return s.findFollowersCounts(filter)
+}
 
-	s.feed.InsertOne(context.TODO(), models.Feed{
-		User:  subscription.From,
-		Posts: make([]models.Post, 0),
-	})
-	_, err := s.subscriptions.InsertOne(context.TODO(), subscription)
-	if err != nil && strings.Contains(err.Error(), "duplicate") {
-		return nil
+func (s *MongoStorage) findFollowersCounts(filter bson.D) ([]models.UserID, error) {
+	cur, err := s.followers.Find(context.TODO(), filter, options.Find())
+	if err != nil {
+		return nil, err
 	}
-	return err
+	defer cur.Close(context.TODO())
+
+	users := make([]models.UserID, 0)
+	for cur.Next(context.TODO()) {
+		var elem models.FollowersCount
+		if err := cur.Decode(&elem); err != nil {
+			return nil, err
+		}
+		users = append(users, elem.User)
+	}
+	return users, cur.Err()
 }
 
 func (s *MongoStorage) GetSubscriptions(userId models.UserID) (models.UsersList, error) {
+	if userId == "" {
+		return models.UsersList{}, models.ErrUnauthorized
+	}
 	cur, err := s.subscriptions.Find(context.TODO(), bson.D{{"from", userId}}, options.Find())
 	if err != nil {
 		return models.UsersList{}, err
@@ -205,10 +1625,13 @@ func (s *MongoStorage) GetSubscriptions(userId models.UserID) (models.UsersList,
 	}
 	cur.Close(context.TODO())
 
-	return models.UsersList{users}, nil
+	return models.UsersList{Users: users}, nil
 }
 
 func (s *MongoStorage) GetSubscribers(userId models.UserID) (models.UsersList, error) {
+	if userId == "" {
+		return models.UsersList{}, models.ErrUnauthorized
+	}
 	cur, err := s.subscriptions.Find(context.TODO(), bson.D{{"to", userId}}, options.Find())
 	if err != nil {
 		return models.UsersList{}, err
@@ -226,7 +1649,7 @@ func (s *MongoStorage) GetSubscribers(userId models.UserID) (models.UsersList, e
 	}
 	cur.Close(context.TODO())
 
-	return models.UsersList{users}, nil
+	return models.UsersList{Users: users}, nil
 }
 
 func (s *MongoStorage) UpdateUserFeed(userId string) error {
@@ -235,14 +1658,11 @@ func (s *MongoStorage) UpdateUserFeed(userId string) error {
 		return err
 	}
 
-	allPosts, err := s.getAllUsersPosts(subscriptions.Users)
+	allPosts, err := s.findUsersPosts(subscriptions.Users, nil, 0, s.feedConfig.MaxLength)
 	if err != nil {
 		return err
 	}
-
-	sort.Slice(allPosts, func(i, j int) bool {
-		return allPosts[i].CreatedTime.After(allPosts[j].CreatedTime)
-	})
+	allPosts = dedupeReposts(allPosts)
 
 	filter := bson.D{{"user", userId}}
 	update := bson.D{{"$set", bson.D{{"posts", allPosts}}}}
@@ -251,15 +1671,222 @@ func (s *MongoStorage) UpdateUserFeed(userId string) error {
 	return err
 }
 
-func (s *MongoStorage) GetFeed(userId models.UserID, page int, size int) (models.PostsPage, error) {
+// FanOutPost puts a new or edited post into feeds of all subscribers of its
+// author, updating the feeds in batches.
+func (s *MongoStorage) FanOutPost(postId string, authorId string) error {
+	// posts of popular authors are merged into feeds on read
+	popularUsers, err := s.getPopularUsers([]models.UserID{models.UserID(authorId)})
+	if err != nil || len(popularUsers) > 0 {
+		return err
+	}
+
+	post, err := s.GetPost(models.PostID(postId))
+	if errors.Is(err, models.ErrNotFound) {
+		// the post has been deleted before the task was run
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	cur, err := s.subscriptions.Find(context.TODO(), bson.D{{"to", authorId}}, options.Find())
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.TODO())
+
+	batch := make([]models.UserID, 0, fanoutBatchSize)
+	for cur.Next(context.TODO()) {
+		var elem models.Subscription
+		if err := cur.Decode(&elem); err != nil {
+			return err
+		}
+		batch = append(batch, elem.From)
+		if len(batch) == fanoutBatchSize {
+			if err := s.addToFeeds(batch, post); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return s.addToFeeds(batch, post)
+	}
+	return nil
+}
+
+// addToFeeds puts the post into already built feeds of the users without
+// recomputing them. It is safe to repeat if the task is retried.
+func (s *MongoStorage) addToFeeds(usersId []models.UserID, post models.Post) error {
+	// an edited post is already in the feed, so it is replaced in place
+	filter := bson.D{{"user", bson.M{"$in": usersId}}, {"posts.id", post.Id}}
+	update := bson.D{{"$set", bson.D{{"posts.$", post}}}}
+	if _, err := s.feed.UpdateMany(context.TODO(), filter, update); err != nil {
+		return err
+	}
+
+	// a post that is already in the feed directly or via another repost is
+	// not added again
+	filter = bson.D{
+		{"user", bson.M{"$in": usersId}},
+		{"posts.id", bson.D{{"$nin", bson.A{post.Id, feedKey(post)}}}},
+		{"posts.repostof", bson.D{{"$ne", feedKey(post)}}},
+	}
+	update = bson.D{{"$push", bson.D{{"posts", bson.D{
+		{"$each", bson.A{post}},
+		{"$sort", bson.D{{"createdtime", -1}, {"id", -1}}},
+		{"$slice", s.feedConfig.MaxLength},
+	}}}}}
+	_, err := s.feed.UpdateMany(context.TODO(), filter, update)
+	return err
+}
+
+func (s *MongoStorage) GetFeed(userId models.UserID, page models.PageRequest) (models.PostsPage, error) {
+	if userId == "" {
+		return models.PostsPage{}, models.ErrUnauthorized
+	}
+
+	filter, err := s.getFeedFilter(userId)
+	if err != nil {
+		return models.PostsPage{}, err
+	}
+	if filter.empty() {
+		return s.getFeedPage(userId, page)
+	}
+
+	// a repost is hidden together with the original post
+	originals := make(map[models.PostID]models.Post)
+	return filterPage(page, func(page models.PageRequest) (models.PostsPage, error) {
+		postsPage, err := s.getFeedPage(userId, page)
+		if err != nil {
+			return postsPage, err
+		}
+		repostsOf := make([]models.PostID, 0)
+		for _, post := range postsPage.Posts {
+			if post.RepostOf != "" {
+				repostsOf = append(repostsOf, post.RepostOf)
+			}
+		}
+		if len(repostsOf) > 0 {
+			found, err := s.findPostsById(repostsOf)
+			if err != nil {
+				return postsPage, err
+			}
+			for postId, post := range found {
+				originals[postId] = post
+			}
+		}
+		return postsPage, nil
+	}, func(post models.Post) bool {
+		if filter.hides(post) {
+			return false
+		}
+		original, found := originals[post.RepostOf]
+		return post.RepostOf == "" || !found || !filter.hides(original)
+	})
+}
+
+func (s *MongoStorage) getFeedPage(userId models.UserID, page models.PageRequest) (models.PostsPage, error) {
 	var result models.Feed
 	if err := s.feed.FindOne(context.TODO(), bson.D{{"user", userId}}).Decode(&result); err != nil {
 		return models.PostsPage{}, err
 	}
-	return getPostsPage(result.Posts, page, size)
+	truncated := len(result.Posts) >= s.feedConfig.MaxLength
+
+	subscriptions, err := s.GetSubscriptions(userId)
+	if err != nil {
+		return models.PostsPage{}, err
+	}
+	popularUsers, err := s.getMergedUsers(subscriptions.Users)
+	if err != nil {
+		return models.PostsPage{}, err
+	}
+
+	posts := result.Posts
+	if len(popularUsers) > 0 {
+		// posts of popular authors are not pushed into feeds, so their latest
+		// posts are merged in here. Posts pushed before the author became
+		// popular are already in the feed and are deduplicated.
+		popularPosts, err := s.findUsersPosts(popularUsers, page.After, 0, page.Offset()+page.Size+1)
+		if err != nil {
+			return models.PostsPage{}, err
+		}
+		if truncated && len(posts) > 0 {
+			oldest := posts[len(posts)-1]
+			popularPosts = popularPosts[:sort.Search(len(popularPosts), func(i int) bool {
+				return isNewer(oldest, popularPosts[i])
+			})]
+		}
+		posts = mergePosts(posts, popularPosts)
+	}
+
+	// A full feed has been cut to its newest posts, so older pages are read
+	// from the posts of the subscriptions directly.
+	if truncated && pageStart(posts, page)+page.Size >= len(posts) {
+		return s.readFeedPage(subscriptions.Users, page)
+	}
+
+	postsPage, err := getPostsPage(posts, page)
+	if err != nil {
+		return postsPage, err
+	}
+	// feeds keep copies made at fan-out, likes have been counted since
+	postsPage.Posts, err = s.refreshPosts(postsPage.Posts)
+	return postsPage, err
+}
+
+// mergePosts merges two lists sorted by isNewer, keeping a single copy of a
+// post present in both of them.
+func mergePosts(posts []models.Post, other []models.Post) []models.Post {
+	merged := make([]models.Post, 0, len(posts)+len(other))
+	merged = append(merged, posts...)
+	merged = append(merged, other...)
+	sort.SliceStable(merged, func(i, j int) bool {
+		return isNewer(merged[i], merged[j])
+	})
+	return dedupeReposts(merged)
+}
+
+// feedKey is the id of the post shown for a feed entry
+func feedKey(post models.Post) models.PostID {
+	if post.RepostOf != "" {
+		return post.RepostOf
+	}
+	return post.Id
+}
+
+// dedupeReposts keeps the earliest entry of a post that gets into the feed
+// both directly and via reposts, the same way fan-out does.
+func dedupeReposts(posts []models.Post) []models.Post {
+	seen := make(map[models.PostID]bool)
+	keep := make([]bool, len(posts))
+	for i := len(posts) - 1; i >= 0; i-- {
+		if key := feedKey(posts[i]); !seen[key] {
+			seen[key] = true
+			keep[i] = true
+		}
+	}
+
+	deduped := make([]models.Post, 0, len(posts))
+	for i, post := range posts {
+		if keep[i] {
+			deduped = append(deduped, post)
+		}
+	}
+	return deduped
+}
+
+func (s *MongoStorage) readFeedPage(subscriptions []models.UserID, page models.PageRequest) (models.PostsPage, error) {
+	posts, err := s.findUsersPosts(subscriptions, page.After, page.Offset(), page.Size+1)
+	if err != nil {
+		return models.PostsPage{}, err
+	}
+	return getPostsPage(posts, models.PageRequest{Size: page.Size})
 }
 
-func NewMongoStorage(mongoUrl string, mongoDbName string) *MongoStorage {
+func NewMongoStorage(mongoUrl string, mongoDbName string, feedConfig FeedConfig) *MongoStorage {
 	clientOptions := options.Client().ApplyURI(mongoUrl)
 	client, err := mongo.Connect(context.TODO(), clientOptions)
 	if err != nil {
@@ -274,8 +1901,42 @@ func NewMongoStorage(mongoUrl string, mongoDbName string) *MongoStorage {
 	posts := client.Database(mongoDbName).Collection("posts")
 	subscriptions := client.Database(mongoDbName).Collection("subscriptions")
 	feed := client.Database(mongoDbName).Collection("feed")
+	followers := client.Database(mongoDbName).Collection("followers")
+	likes := client.Database(mongoDbName).Collection("likes")
+	notifications := client.Database(mongoDbName).Collection("notifications")
+	revisions := client.Database(mongoDbName).Collection("revisions")
+	users := client.Database(mongoDbName).Collection("users")
+	blocks := client.Database(mongoDbName).Collection("blocks")
+	mutes := client.Database(mongoDbName).Collection("mutes")
+	followRequests := client.Database(mongoDbName).Collection("followrequests")
+
+	// serves both author lookups and paging through an author's posts
+	addIndex(posts, "authorid", "_id")
+	addIndex(posts, "authorid", "createdtime", "_id")
+	addIndex(posts, "inreplyto", "_id")
+	addIndex(posts, "repostof")
+	addIndex(posts, "tags", "_id")
+	addIndex(posts, "mentions", "_id")
+
+	// a user reposts a post at most once
+	if _, err := posts.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{"authorid", 1}, {"repostof", 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.D{{"repostof", bson.D{{"$exists", true}}}}),
+	}); err != nil {
+		panic(err)
+	}
+
+	// the language is not known and posts are written in several ones, so
+	// words are not stemmed and stop words are not removed
+	if _, err := posts.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{"text", "text"}},
+		Options: options.Index().SetDefaultLanguage("none"),
+	}); err != nil {
+		panic(err)
+	}
 
-	addIndex(posts, "authorid")
 	addIndex(subscriptions, "from")
 	addIndex(subscriptions, "to")
 
@@ -293,9 +1954,88 @@ func NewMongoStorage(mongoUrl string, mongoDbName string) *MongoStorage {
 		panic(err)
 	}
 
-	return &MongoStorage{
-		posts:         posts,
-		subscriptions: subscriptions,
-		feed:          feed,
+	if _, err := followers.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{"user", 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		panic(err)
+	}
+
+	if _, err := likes.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{"postid", 1}, {"userid", 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		panic(err)
+	}
+
+	if _, err := notifications.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{"userid", 1}, {"type", 1}, {"actorid", 1}, {"postid", 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		panic(err)
+	}
+	addIndex(revisions, "postid", "_id")
+
+	if _, err := blocks.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{"from", 1}, {"to", 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		panic(err)
+	}
+	addIndex(blocks, "to")
+
+	if _, err := followRequests.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{"from", 1}, {"to", 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		panic(err)
+	}
+	addIndex(followRequests, "to", "_id")
+
+	if _, err := mutes.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{"userid", 1}, {"muteduserid", 1}, {"keyword", 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		panic(err)
+	}
+	// expired mutes are removed by mongo, reads skip those not removed yet
+	if _, err := mutes.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{"expirestime", 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}); err != nil {
+		panic(err)
+	}
+
+	// users without a handle do not collide
+	if _, err := users.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{"handle", 1}},
+		Options: options.Index().
+			SetName(handleIndex).
+			SetUnique(true).
+			SetPartialFilterExpression(bson.D{{"handle", bson.D{{"$exists", true}}}}),
+	}); err != nil {
+		panic(err)
+	}
+
+	addIndex(notifications, "userid", "_id")
+	addIndex(notifications, "userid", "read")
+
+	s := &MongoStorage{
+		posts:          posts,
+		subscriptions:  subscriptions,
+		feed:           feed,
+		followers:      followers,
+		likes:          likes,
+		notifications:  notifications,
+		revisions:      revisions,
+		users:          users,
+		blocks:         blocks,
+		mutes:          mutes,
+		followRequests: followRequests,
+		feedConfig:     feedConfig,
+	}
+	if err := s.backfillFollowers(); err != nil {
+		panic(err)
 	}
+	return s
 }
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ikolcov/microblog/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	benchmarkAuthor    = models.UserID("benchmark-author")
+	benchmarkPostCount = 100000
+	benchmarkPageSize  = 10
+)
+
+// newBenchmarkStorage connects to the database of MONGO_TEST_URL and fills it
+// with posts of a single author once. The benchmarks are skipped without it:
+//
+//	MONGO_TEST_URL=mongodb://localhost:27017 go test -run '^$' -bench FindPostsPage ./internal/storage
+func newBenchmarkStorage(b *testing.B) *MongoStorage {
+	mongoUrl := os.Getenv("MONGO_TEST_URL")
+	if mongoUrl == "" {
+		b.Skip("MONGO_TEST_URL is not set")
+	}
+	s := NewMongoStorage(mongoUrl, "microblog_benchmark", FeedConfig{MaxLength: 1000, FanoutThreshold: 10000})
+
+	count, err := s.posts.CountDocuments(context.TODO(), bson.D{{"authorid", benchmarkAuthor}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	createdTime := time.Now()
+	for count < benchmarkPostCount {
+		batch := make([]interface{}, 0, 1000)
+		for i := 0; i < cap(batch) && count < benchmarkPostCount; i++ {
+			createdTime = createdTime.Add(time.Millisecond)
+			batch = append(batch, models.Post{
+				Text:        fmt.Sprintf("post %d", count),
+				AuthorId:    benchmarkAuthor,
+				CreatedAt:   createdTime.Format("2006-01-02T15:04:05.999Z"),
+				CreatedTime: createdTime,
+				Version:     1,
+			})
+			count++
+		}
+		if _, err := s.posts.InsertMany(context.TODO(), batch); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return s
+}
+
+// cursorAt returns the cursor of the post right before the offset, as the
+// previous page would have returned it
+func cursorAt(b *testing.B, s *MongoStorage, offset int) *models.Cursor {
+	if offset == 0 {
+		return nil
+	}
+	findOptions := options.Find().SetSort(bson.D{{"_id", -1}}).SetSkip(int64(offset - 1)).SetLimit(1)
+	cur, err := s.posts.Find(context.TODO(), bson.D{{"authorid", benchmarkAuthor}}, findOptions)
+	if err != nil {
+		b.Fatal(err)
+	}
+	posts, err := decodePosts(cur)
+	if err != nil || len(posts) == 0 {
+		b.Fatal("no post at offset", offset, err)
+	}
+	return &models.Cursor{Id: posts[0].Id, Time: posts[0].CreatedTime}
+}
+
+// BenchmarkFindPostsPage compares fetching shallow and deep pages of an
+// author's posts. Cursor pages seek by the index on (authorid, _id), so they
+// take the same time at any depth. The deprecated numeric page falls back to
+// SetSkip, which still walks over all skipped posts and costs O(offset).
+func BenchmarkFindPostsPage(b *testing.B) {
+	s := newBenchmarkStorage(b)
+	filter := bson.D{{"authorid", benchmarkAuthor}}
+
+	for _, offset := range []int{0, benchmarkPostCount / 10, benchmarkPostCount - 10*benchmarkPageSize} {
+		b.Run(fmt.Sprintf("cursor/offset=%d", offset), func(b *testing.B) {
+			page := models.PageRequest{After: cursorAt(b, s, offset), Size: benchmarkPageSize}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.findPostsPage(filter, page); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("page/offset=%d", offset), func(b *testing.B) {
+			page := models.PageRequest{Page: offset/benchmarkPageSize + 1, Size: benchmarkPageSize}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.findPostsPage(filter, page); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,10 +1,163 @@
 package storage
 
-import "github.com/ikolcov/microblog/internal/models"
+import (
+	"errors"
+
+	"github.com/ikolcov/microblog/internal/models"
+	"github.com/ikolcov/microblog/internal/utils"
+)
+
+// maxThreadDepth limits the number of ancestors returned with a thread
+const maxThreadDepth = 100
 
 type Storage interface {
 	AddPost(post models.Post) (models.PostID, error)
 	GetPost(postId models.PostID) (models.Post, error)
+	// GetVisiblePost returns the post, or the reposted post for a repost, if
+	// the user may see it
+	GetVisiblePost(userId models.UserID, postId models.PostID) (models.Post, error)
 	UpdatePost(postUpdate models.Post) (models.Post, error)
-	GetUserPosts(userId models.UserID, page int, size int) (models.PostsPage, error)
+	// GetRevisions returns previous versions of the post, oldest first
+	GetRevisions(postId models.PostID) (models.RevisionsList, error)
+	GetUserPosts(userId models.UserID, page models.PageRequest) (models.PostsPage, error)
+	GetReplies(postId models.PostID, page models.PageRequest) (models.PostsPage, error)
+	GetThread(postId models.PostID, page models.PageRequest) (models.Thread, error)
+	GetTagPosts(tag string, page models.PageRequest) (models.PostsPage, error)
+	GetMentions(userId models.UserID, page models.PageRequest) (models.PostsPage, error)
+	SearchPosts(query models.SearchQuery, page models.PageRequest) (models.PostsPage, error)
+	DeletePost(postId models.PostID, userId models.UserID) error
+	// DeleteRepost undoes the repost of the post by the user and returns the
+	// id of the removed repost record
+	DeleteRepost(postId models.PostID, userId models.UserID) (models.PostID, error)
+	LikePost(postId models.PostID, userId models.UserID) error
+	UnlikePost(postId models.PostID, userId models.UserID) error
+	// BlockUser also removes subscriptions between the users in both
+	// directions
+	BlockUser(userId models.UserID, blockedId models.UserID) error
+	UnblockUser(userId models.UserID, blockedId models.UserID) error
+	// IsBlocked checks whether the user has blocked the other one
+	IsBlocked(userId models.UserID, otherId models.UserID) (bool, error)
+	// CanSeePosts checks whether the viewer may see posts of the author
+	CanSeePosts(viewerId models.UserID, authorId models.UserID) (bool, error)
+	// DecoratePosts fills in the fields of posts that depend on the user
+	// requesting them, dropping the posts the user may not see
+	DecoratePosts(viewerId models.UserID, posts []models.Post) ([]models.Post, error)
+}
+
+// feedFilter hides posts of blocked and muted users and posts containing
+// muted phrases
+type feedFilter struct {
+	hiddenUsers map[models.UserID]bool
+	phrases     [][]string
+}
+
+func (f feedFilter) empty() bool {
+	return len(f.hiddenUsers) == 0 && len(f.phrases) == 0
+}
+
+func (f feedFilter) hides(post models.Post) bool {
+	if f.hiddenUsers[post.AuthorId] {
+		return true
+	}
+	if len(f.phrases) == 0 {
+		return false
+	}
+	words := utils.SplitWords(post.Text)
+	for _, phrase := range f.phrases {
+		if containsPhrase(words, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsPhrase checks whether the words of the phrase follow each other in
+// the text
+func containsPhrase(words []string, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		matches := true
+		for j, word := range phrase {
+			if words[i+j] != word {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// filterPage reads pages until it collects a full page of posts accepted by
+// keep, so that filtering does not leave pages short. A numeric page skips
+// the accepted posts of the pages before it, so it is read from the start.
+func filterPage(page models.PageRequest, readPage func(models.PageRequest) (models.PostsPage, error), keep func(models.Post) bool) (models.PostsPage, error) {
+	result := models.PostsPage{
+		Posts: make([]models.Post, 0, page.Size),
+	}
+	skip := page.Offset()
+	page = models.PageRequest{After: page.After, Size: page.Size}
+	for {
+		postsPage, err := readPage(page)
+		if err != nil {
+			return models.PostsPage{}, err
+		}
+		for _, post := range postsPage.Posts {
+			if !keep(post) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if len(result.Posts) == page.Size {
+				// a post is left after the page, so there is a next one
+				last := result.Posts[len(result.Posts)-1]
+				result.Next = &models.Cursor{Id: last.Id, Time: last.CreatedTime}
+				return result, nil
+			}
+			result.Posts = append(result.Posts, post)
+		}
+		if postsPage.Next == nil {
+			return result, nil
+		}
+		page = models.PageRequest{After: postsPage.Next, Size: page.Size}
+	}
+}
+
+// getThread collects the chain of posts the given post replies to, starting
+// from the root of the conversation, and the first page of direct replies.
+func getThread(s Storage, postId models.PostID, page models.PageRequest) (models.Thread, error) {
+	post, err := s.GetPost(postId)
+	if err != nil {
+		return models.Thread{}, err
+	}
+
+	ancestors := make([]models.Post, 0)
+	for parentId := post.InReplyTo; parentId != "" && len(ancestors) < maxThreadDepth; {
+		parent, err := s.GetPost(parentId)
+		if errors.Is(err, models.ErrNotFound) {
+			// the rest of the chain is cut off by a deleted post
+			break
+		} else if err != nil {
+			return models.Thread{}, err
+		}
+		ancestors = append(ancestors, parent)
+		parentId = parent.InReplyTo
+	}
+	for l, r := 0, len(ancestors)-1; l < r; l, r = l+1, r-1 {
+		ancestors[l], ancestors[r] = ancestors[r], ancestors[l]
+	}
+
+	replies, err := s.GetReplies(postId, page)
+	if err != nil {
+		return models.Thread{}, err
+	}
+
+	return models.Thread{
+		Ancestors: ancestors,
+		Post:      post,
+		Replies:   replies,
+	}, nil
 }
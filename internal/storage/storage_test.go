@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/ikolcov/microblog/internal/models"
+)
+
+// pagedPosts serves posts with ids from count down to 1 in pages, the way
+// storages serve them newest first
+func pagedPosts(count int, reads *int) func(models.PageRequest) (models.PostsPage, error) {
+	return func(page models.PageRequest) (models.PostsPage, error) {
+		*reads++
+		from := page.Offset()
+		if page.After != nil {
+			lastId, _ := strconv.Atoi(string(page.After.Id))
+			from = count - lastId + 1
+		}
+		postsPage := models.PostsPage{Posts: make([]models.Post, 0)}
+		for i := from; i < from+page.Size && i < count; i++ {
+			postsPage.Posts = append(postsPage.Posts, models.Post{Id: models.PostID(fmt.Sprint(count - i))})
+		}
+		if from+page.Size < count {
+			last := postsPage.Posts[len(postsPage.Posts)-1]
+			postsPage.Next = &models.Cursor{Id: last.Id}
+		}
+		return postsPage, nil
+	}
+}
+
+func TestFilterPage(t *testing.T) {
+	isEven := func(post models.Post) bool {
+		id, _ := strconv.Atoi(string(post.Id))
+		return id%2 == 0
+	}
+	keepAll := func(models.Post) bool { return true }
+	keepNone := func(models.Post) bool { return false }
+
+	tests := []struct {
+		name      string
+		count     int
+		page      models.PageRequest
+		keep      func(models.Post) bool
+		want      []string
+		wantNext  string
+		wantReads int
+	}{
+		{"nothing filtered", 10, models.PageRequest{Size: 3}, keepAll, []string{"10", "9", "8"}, "8", 2},
+		{"filtered page is refilled", 10, models.PageRequest{Size: 3}, isEven, []string{"10", "8", "6"}, "6", 3},
+		{"cursor", 10, models.PageRequest{After: &models.Cursor{Id: "6"}, Size: 3}, isEven, []string{"4", "2"}, "", 2},
+		{"numeric page", 10, models.PageRequest{Page: 2, Size: 3}, isEven, []string{"4", "2"}, "", 4},
+		{"numeric page not filtered", 10, models.PageRequest{Page: 2, Size: 3}, keepAll, []string{"7", "6", "5"}, "5", 3},
+		{"last page is exactly full", 6, models.PageRequest{Size: 3}, isEven, []string{"6", "4", "2"}, "", 2},
+		{"everything filtered", 10, models.PageRequest{Size: 3}, keepNone, []string{}, "", 4},
+		{"no posts", 0, models.PageRequest{Size: 3}, keepAll, []string{}, "", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reads := 0
+			postsPage, err := filterPage(test.page, pagedPosts(test.count, &reads), test.keep)
+			if err != nil {
+				t.Fatalf("filterPage() error = %v", err)
+			}
+			if got := postIds(postsPage.Posts); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("filterPage() posts = %q, want %q", got, test.want)
+			}
+			next := ""
+			if postsPage.Next != nil {
+				next = string(postsPage.Next.Id)
+			}
+			if next != test.wantNext {
+				t.Errorf("filterPage() next = %q, want %q", next, test.wantNext)
+			}
+			if reads != test.wantReads {
+				t.Errorf("filterPage() read %d pages, want %d", reads, test.wantReads)
+			}
+		})
+	}
+}
+
+func TestContainsPhrase(t *testing.T) {
+	tests := []struct {
+		words  []string
+		phrase []string
+		want   bool
+	}{
+		{[]string{"spoilers", "ahead"}, []string{"spoilers"}, true},
+		{[]string{"big", "spoilers", "ahead"}, []string{"spoilers", "ahead"}, true},
+		{[]string{"spoilers", "are", "ahead"}, []string{"spoilers", "ahead"}, false},
+		{[]string{"ahead", "spoilers"}, []string{"spoilers", "ahead"}, false},
+		{[]string{"spoilers"}, []string{"spoilers", "ahead"}, false},
+		{[]string{"unspoilered"}, []string{"spoilers"}, false},
+		{nil, []string{"spoilers"}, false},
+	}
+	for _, test := range tests {
+		if got := containsPhrase(test.words, test.phrase); got != test.want {
+			t.Errorf("containsPhrase(%q, %q) = %v, want %v", test.words, test.phrase, got, test.want)
+		}
+	}
+}
+
+func TestFeedFilterHides(t *testing.T) {
+	filter := feedFilter{
+		hiddenUsers: map[models.UserID]bool{"muted": true},
+		phrases:     [][]string{{"spoilers", "ahead"}},
+	}
+
+	tests := []struct {
+		post models.Post
+		want bool
+	}{
+		{models.Post{AuthorId: "muted", Text: "hello"}, true},
+		{models.Post{AuthorId: "friend", Text: "hello"}, false},
+		{models.Post{AuthorId: "friend", Text: "Spoilers, ahead!"}, true},
+		{models.Post{AuthorId: "friend", Text: "no spoilers here, go ahead"}, false},
+	}
+	for _, test := range tests {
+		if got := filter.hides(test.post); got != test.want {
+			t.Errorf("hides(%+v) = %v, want %v", test.post, got, test.want)
+		}
+	}
+}
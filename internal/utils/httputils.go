@@ -1,8 +1,13 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 func RespondJSON(w http.ResponseWriter, status int, data interface{}) error {
@@ -43,3 +48,56 @@ func NotFound(w http.ResponseWriter, message string) {
 	_, _ = w.Write([]byte(message))
 	_, _ = w.Write([]byte("\n"))
 }
+
+func Conflict(w http.ResponseWriter, message string) {
+	w.WriteHeader(http.StatusConflict)
+	_, _ = w.Write([]byte(message))
+	_, _ = w.Write([]byte("\n"))
+}
+
+func PreconditionFailed(w http.ResponseWriter, message string) {
+	w.WriteHeader(http.StatusPreconditionFailed)
+	_, _ = w.Write([]byte(message))
+	_, _ = w.Write([]byte("\n"))
+}
+
+func UnprocessableEntity(w http.ResponseWriter, message string) {
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	_, _ = w.Write([]byte(message))
+	_, _ = w.Write([]byte("\n"))
+}
+
+func NotModified(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// RepresentationETag tags a rendered body of a post. It starts with the
+// version of the post, so that it is accepted by If-Match, and changes with
+// anything else the body shows as well.
+func RepresentationETag(version int64, body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf("\"%d-%s\"", version, base64.RawURLEncoding.EncodeToString(sum[:12]))
+}
+
+// ParseETag returns the version from a single ETag made by
+// RepresentationETag, or from an older one holding the version only
+func ParseETag(etag string) (int64, error) {
+	etag = strings.TrimSpace(etag)
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return 0, fmt.Errorf("invalid etag %s", etag)
+	}
+	version, _, _ := strings.Cut(etag[1:len(etag)-1], "-")
+	return strconv.ParseInt(version, 10, 64)
+}
+
+// MatchETag checks whether the list of ETags from If-None-Match matches the
+// given one, comparing them weakly
+func MatchETag(header string, etag string) bool {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+		if value == "*" || value == etag {
+			return true
+		}
+	}
+	return false
+}
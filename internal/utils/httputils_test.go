@@ -0,0 +1,71 @@
+package utils
+
+import "testing"
+
+func TestParseETag(t *testing.T) {
+	tests := []struct {
+		etag    string
+		want    int64
+		wantErr bool
+	}{
+		{`"1"`, 1, false},
+		{`"42"`, 42, false},
+		{` "7" `, 7, false},
+		{`7`, 0, true},
+		{`"`, 0, true},
+		{`""`, 0, true},
+		{`"abc"`, 0, true},
+		{`W/"7"`, 0, true},
+		{`"7-abc"`, 7, false},
+		{RepresentationETag(3, []byte(`{"id":"1"}`)), 3, false},
+		{`"-abc"`, 0, true},
+	}
+	for _, test := range tests {
+		got, err := ParseETag(test.etag)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("ParseETag(%q) = %d, %v, want %d, error %v", test.etag, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestRepresentationETag(t *testing.T) {
+	body := []byte(`{"id":"1","likesCount":0}`)
+	etag := RepresentationETag(1, body)
+
+	tests := []struct {
+		name string
+		etag string
+		same bool
+	}{
+		{"same body", RepresentationETag(1, body), true},
+		{"other body", RepresentationETag(1, []byte(`{"id":"1","likesCount":1}`)), false},
+		{"other version", RepresentationETag(2, body), false},
+		{"version only", `"1"`, false},
+	}
+	for _, test := range tests {
+		if got := MatchETag(test.etag, etag); got != test.same {
+			t.Errorf("%s: MatchETag(%q, %q) = %v, want %v", test.name, test.etag, etag, got, test.same)
+		}
+	}
+}
+
+func TestMatchETag(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		want   bool
+	}{
+		{`"1"`, `"1"`, true},
+		{`"2"`, `"1"`, false},
+		{`"2", "1"`, `"1"`, true},
+		{`W/"1"`, `"1"`, true},
+		{`*`, `"1"`, true},
+		{`1`, `"1"`, false},
+		{``, `"1"`, false},
+	}
+	for _, test := range tests {
+		if got := MatchETag(test.header, test.etag); got != test.want {
+			t.Errorf("MatchETag(%q, %q) = %v, want %v", test.header, test.etag, got, test.want)
+		}
+	}
+}
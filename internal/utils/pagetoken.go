@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/ikolcov/microblog/internal/models"
+)
+
+const signatureSize = 16
+
+// PageTokenCodec turns cursors into page tokens signed with a secret key, so
+// clients cannot forge a token pointing at an arbitrary position.
+type PageTokenCodec struct {
+	key []byte
+}
+
+func NewPageTokenCodec(key []byte) *PageTokenCodec {
+	return &PageTokenCodec{key: key}
+}
+
+func (c *PageTokenCodec) Encode(cursor models.Cursor) string {
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(append(payload, c.sign(payload)...))
+}
+
+func (c *PageTokenCodec) Decode(token string) (models.Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) <= signatureSize {
+		return models.Cursor{}, models.ErrBadRequest
+	}
+
+	payload, signature := data[:len(data)-signatureSize], data[len(data)-signatureSize:]
+	if !hmac.Equal(signature, c.sign(payload)) {
+		return models.Cursor{}, models.ErrBadRequest
+	}
+
+	var cursor models.Cursor
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return models.Cursor{}, models.ErrBadRequest
+	}
+	return cursor, nil
+}
+
+func (c *PageTokenCodec) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(payload)
+	return mac.Sum(nil)[:signatureSize]
+}
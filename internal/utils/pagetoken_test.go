@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ikolcov/microblog/internal/models"
+)
+
+func TestPageTokenCodecRoundTrip(t *testing.T) {
+	codec := NewPageTokenCodec([]byte("secret"))
+	createdTime := time.Date(2023, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		cursor models.Cursor
+	}{
+		{"empty", models.Cursor{}},
+		{"post", models.Cursor{Id: "6450b3f1e4b0a1a2b3c4d5e6", Time: createdTime}},
+		{"search", models.Cursor{Id: "42", Time: createdTime, Score: 1.5}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cursor, err := codec.Decode(codec.Encode(test.cursor))
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if cursor.Id != test.cursor.Id || !cursor.Time.Equal(test.cursor.Time) || cursor.Score != test.cursor.Score {
+				t.Errorf("Decode() = %+v, want %+v", cursor, test.cursor)
+			}
+		})
+	}
+}
+
+func TestPageTokenCodecRejectsInvalidTokens(t *testing.T) {
+	codec := NewPageTokenCodec([]byte("secret"))
+	token := codec.Encode(models.Cursor{Id: "42"})
+	tampered := []byte(token)
+	tampered[0] ^= 1
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"not base64", "not a token!"},
+		{"too short", "AAAA"},
+		{"numeric page", "2"},
+		{"tampered", string(tampered)},
+		{"other key", NewPageTokenCodec([]byte("other")).Encode(models.Cursor{Id: "42"})},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := codec.Decode(test.token); err != models.ErrBadRequest {
+				t.Errorf("Decode(%q) error = %v, want %v", test.token, err, models.ErrBadRequest)
+			}
+		})
+	}
+}
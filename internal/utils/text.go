@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// a hashtag starts a word, so that anchors in links like a.com/#top are skipped
+var hashtagRegexp = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_/#&])#([\p{L}\p{N}_]+)`)
+
+// a mention starts a word, so that e-mail addresses are skipped
+var mentionRegexp = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])@([\p{L}\p{N}_-]+)`)
+
+var wordRegexp = regexp.MustCompile(`[\p{L}\p{N}_]+`)
+
+// ExtractHashtags returns distinct lowercased hashtags of the text without
+// the leading #, in the order of their first occurrence.
+func ExtractHashtags(text string) []string {
+	return extractDistinct(hashtagRegexp, text)
+}
+
+// ExtractMentions returns distinct names mentioned in the text as @name, in
+// the order of their first occurrence. A name is either a handle or a user
+// id, so it is returned as written and is up to the caller to resolve.
+func ExtractMentions(text string) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, match := range mentionRegexp.FindAllStringSubmatch(text, -1) {
+		if name := match[1]; !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// SplitWords returns lowercased words of the text in their order, including
+// repeated ones.
+func SplitWords(text string) []string {
+	words := wordRegexp.FindAllString(text, -1)
+	for i, word := range words {
+		words[i] = strings.ToLower(word)
+	}
+	return words
+}
+
+func NormalizeHashtag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+func extractDistinct(re *regexp.Regexp, text string) []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0)
+	for _, match := range re.FindAllStringSubmatch(text, -1) {
+		value := strings.ToLower(match[1])
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
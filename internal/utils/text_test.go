@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractHashtags(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"no tags here", []string{}},
+		{"#go is #fun", []string{"go", "fun"}},
+		{"#Go and #go again", []string{"go"}},
+		{"tags end at punctuation: #go, #rust.", []string{"go", "rust"}},
+		{"#привет мир", []string{"привет"}},
+		{"anchors like a.com/#top are skipped", []string{}},
+		{"so are double ##hashes and a#b", []string{}},
+	}
+	for _, test := range tests {
+		if got := ExtractHashtags(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ExtractHashtags(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestExtractMentions(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"@alice hi", []string{"alice"}},
+		{"hi @alice and @bob, @alice", []string{"alice", "bob"}},
+		{"ids keep their case: @Bob @bob", []string{"Bob", "bob"}},
+		{"@user-1. @user_2!", []string{"user-1", "user_2"}},
+		{"(@6450b3f1e4b0a1a2b3c4d5e6)", []string{"6450b3f1e4b0a1a2b3c4d5e6"}},
+		{"e-mails like alice@example.com are skipped", []string{}},
+		{"a lone @ is not a mention", []string{}},
+	}
+	for _, test := range tests {
+		if got := ExtractMentions(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ExtractMentions(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"Hello, World!", []string{"hello", "world"}},
+		{"go go  GO", []string{"go", "go", "go"}},
+		{"snake_case and #tags", []string{"snake_case", "and", "tags"}},
+	}
+	for _, test := range tests {
+		if got := SplitWords(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SplitWords(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
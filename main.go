@@ -8,6 +8,7 @@ import (
 	"github.com/RichardKnop/machinery/v1/config"
 	"github.com/RichardKnop/machinery/v1/log"
 	"github.com/ikolcov/microblog/internal/app"
+	"github.com/ikolcov/microblog/internal/auth"
 	"github.com/ikolcov/microblog/internal/storage"
 )
 
@@ -20,6 +21,34 @@ func getServerPort() uint16 {
 	panic("Port should be set in env var SERVER_PORT")
 }
 
+func getFeedConfig() storage.FeedConfig {
+	feedConfig := storage.FeedConfig{
+		MaxLength:       1000,
+		FanoutThreshold: 10000,
+	}
+	if maxLength, err := strconv.Atoi(os.Getenv("FEED_MAX_LENGTH")); err == nil && maxLength > 0 {
+		feedConfig.MaxLength = maxLength
+	}
+	if threshold, err := strconv.Atoi(os.Getenv("FEED_FANOUT_THRESHOLD")); err == nil && threshold >= 0 {
+		feedConfig.FanoutThreshold = threshold
+	}
+	return feedConfig
+}
+
+func getAuthConfig() auth.Config {
+	authConfig := auth.Config{
+		JWTKey: os.Getenv("AUTH_JWT_KEY"),
+	}
+	if allow, err := strconv.ParseBool(os.Getenv("AUTH_ALLOW_USER_ID_HEADER")); err == nil {
+		authConfig.AllowUserIdHeader = allow
+	} else if authConfig.JWTKey == "" {
+		// deployments made before tokens were introduced keep working
+		log.WARNING.Println("Neither AUTH_JWT_KEY nor AUTH_ALLOW_USER_ID_HEADER is set, trusting the System-Design-User-Id header")
+		authConfig.AllowUserIdHeader = true
+	}
+	return authConfig
+}
+
 func startServer(redisUrl string, storage *storage.MongoStorage) (*machinery.Server, error) {
 	cnf := &config.Config{
 		DefaultQueue:    "machinery_tasks",
@@ -44,7 +73,9 @@ func startServer(redisUrl string, storage *storage.MongoStorage) (*machinery.Ser
 
 	// Register tasks
 	tasks := map[string]interface{}{
-		"notify": storage.UpdateUserFeed,
+		"notify":       storage.UpdateUserFeed,
+		"fanout":       storage.FanOutPost,
+		"notification": storage.CreateNotifications,
 	}
 
 	return server, server.RegisterTasks(tasks)
@@ -80,11 +111,15 @@ func main() {
 			MongoUrl:    mongoUrl,
 			MongoDbName: mongoDbName,
 			RedisUrl:    redisUrl,
+
+			PageTokenSecret: os.Getenv("PAGE_TOKEN_SECRET"),
+			Feed:            getFeedConfig(),
+			Auth:            getAuthConfig(),
 		}
 
 		app.New(appConfig, machineryServer).Start()
 	case "WORKER":
-		machineryServer, err := startServer(redisUrl, storage.NewMongoStorage(mongoUrl, mongoDbName))
+		machineryServer, err := startServer(redisUrl, storage.NewMongoStorage(mongoUrl, mongoDbName, getFeedConfig()))
 		if err != nil {
 			panic(err)
 		}